@@ -1,11 +1,18 @@
 package mailyak
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/textproto"
+	"strings"
+	"unicode/utf8"
 )
 
 // DetectContentType needs at most 512 bytes
@@ -19,11 +26,88 @@ type writeWrapper interface {
 	new(w io.Writer) io.Writer
 }
 
+// ErrAttachmentConsumed is returned when an email is built (such as by Send or
+// MimeBuf) more than once with an attachment read from an io.Reader that cannot
+// be rewound, rather than sending an empty attachment.
+//
+// Send returns ErrAttachmentConsumed before connecting to the SMTP server, so
+// no part of the email is delivered.
+var ErrAttachmentConsumed = errors.New("mailyak: attachment reader has already been read")
+
 type attachment struct {
 	filename string
 	content  io.Reader
 	inline   bool
 	mimeType string
+
+	// open returns a reader of the attachment content each time the email
+	// is built, or is nil if content is read directly.
+	open func() (io.ReadCloser, error)
+}
+
+// newReaderAttachment returns an attachment reading from r.
+//
+// If r is an io.Seeker, the attachment rewinds r to its current offset each
+// time the email is built so the attachment can be sent repeatedly. Otherwise
+// r can only be read once, and building the email again returns
+// ErrAttachmentConsumed.
+func newReaderAttachment(name string, r io.Reader, inline bool, mimeType string) attachment {
+	a := attachment{
+		filename: name,
+		content:  r,
+		inline:   inline,
+		mimeType: mimeType,
+	}
+
+	// The reader is only consumed once read, so a send that fails before the
+	// email is built can be retried.
+	consumed := false
+	a.open = func() (io.ReadCloser, error) {
+		if consumed {
+			return nil, fmt.Errorf("%w: %q", ErrAttachmentConsumed, name)
+		}
+		return ioutil.NopCloser(&consumingReader{r: r, consumed: &consumed}), nil
+	}
+
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		return a
+	}
+
+	offset, err := rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		// Not actually seekable, such as a pipe.
+		return a
+	}
+
+	a.open = func() (io.ReadCloser, error) {
+		if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(rs), nil
+	}
+
+	return a
+}
+
+// consumingReader reads from r, recording that r has been consumed.
+type consumingReader struct {
+	r        io.Reader
+	consumed *bool
+}
+
+func (c *consumingReader) Read(p []byte) (int, error) {
+	*c.consumed = true
+	return c.r.Read(p)
+}
+
+// reader returns a reader of the attachment content, which must be closed
+// after use.
+func (a attachment) reader() (io.ReadCloser, error) {
+	if a.open != nil {
+		return a.open()
+	}
+	return ioutil.NopCloser(a.content), nil
 }
 
 // Attach adds the contents of r to the email as an attachment with name as the
@@ -31,25 +115,53 @@ type attachment struct {
 //
 // r is not read until Send is called and the MIME type will be detected
 // using https://golang.org/pkg/net/http/#DetectContentType
+//
+// If r implements io.Seeker (such as an *os.File or *bytes.Reader) it is
+// rewound each time the email is built, allowing the email to be sent more than
+// once. Other readers can only be read once, and building the email again
+// returns ErrAttachmentConsumed - use AttachBytes or AttachFunc to attach
+// content that is sent more than once.
 func (m *MailYak) Attach(name string, r io.Reader) {
-	m.attachments = append(m.attachments, attachment{
-		filename: name,
-		content:  r,
-		inline:   false,
-	})
+	m.attachments = append(m.attachments, newReaderAttachment(name, r, false, ""))
 }
 
 // AttachWithMimeType adds the contents of r to the email as an attachment with
 // name as the filename and mimeType as the specified MIME type of the content.
 // It is up to the user to ensure the mimeType is correct.
 //
-// r is not read until Send is called.
+// r is not read until Send is called, and is rewound for each send as
+// described in Attach.
 func (m *MailYak) AttachWithMimeType(name string, r io.Reader, mimeType string) {
+	m.attachments = append(m.attachments, newReaderAttachment(name, r, false, mimeType))
+}
+
+// AttachBytes adds a copy of data to the email as an attachment with name as
+// the filename.
+//
+// The MIME type will be detected using
+// https://golang.org/pkg/net/http/#DetectContentType
+func (m *MailYak) AttachBytes(name string, data []byte) {
+	m.Attach(name, bytes.NewReader(append([]byte{}, data...)))
+}
+
+// AttachFunc adds an attachment with name as the filename, with the content
+// read from the reader returned by open.
+//
+// open is called each time the email is built (for each call to Send or
+// MimeBuf) and the returned reader is closed after use, allowing the email to
+// be sent repeatedly without holding the content in memory:
+//
+//	mail.AttachFunc("report.csv", func() (io.ReadCloser, error) {
+//	    return os.Open("/path/to/report.csv")
+//	})
+//
+// The MIME type will be detected using
+// https://golang.org/pkg/net/http/#DetectContentType
+func (m *MailYak) AttachFunc(name string, open func() (io.ReadCloser, error)) {
 	m.attachments = append(m.attachments, attachment{
 		filename: name,
-		content:  r,
 		inline:   false,
-		mimeType: mimeType,
+		open:     open,
 	})
 }
 
@@ -62,14 +174,21 @@ func (m *MailYak) AttachWithMimeType(name string, r io.Reader, mimeType string)
 //
 //	<img src="cid:myFileName"/>
 //
+// A name containing non-ASCII characters cannot be used as a Content-ID, and
+// is replaced with a token generated by ContentID:
+//
+//	html := `<img src="cid:` + mailyak.ContentID("Logo März.png") + `"/>`
+//
+// Inline attachments are grouped with the email body in a multipart/related
+// part, so email clients display them within the body rather than as
+// attachments.
+//
 // r is not read until Send is called and the MIME type will be detected
 // using https://golang.org/pkg/net/http/#DetectContentType
+//
+// r is rewound for each send as described in Attach.
 func (m *MailYak) AttachInline(name string, r io.Reader) {
-	m.attachments = append(m.attachments, attachment{
-		filename: name,
-		content:  r,
-		inline:   true,
-	})
+	m.attachments = append(m.attachments, newReaderAttachment(name, r, true, ""))
 }
 
 // AttachInlineWithMimeType adds the contents of r to the email as an inline attachment
@@ -82,70 +201,159 @@ func (m *MailYak) AttachInline(name string, r io.Reader) {
 //
 //	<img src="cid:myFileName"/>
 //
-// r is not read until Send is called.
+// A name containing non-ASCII characters cannot be used as a Content-ID, and
+// is replaced with a token generated by ContentID:
+//
+//	html := `<img src="cid:` + mailyak.ContentID("Logo März.png") + `"/>`
+//
+// r is not read until Send is called, and is rewound for each send as
+// described in Attach.
 func (m *MailYak) AttachInlineWithMimeType(name string, r io.Reader, mimeType string) {
+	m.attachments = append(m.attachments, newReaderAttachment(name, r, true, mimeType))
+}
+
+// AttachInlineBytes adds a copy of data to the email as an inline attachment,
+// referenced within the email body by name as described in AttachInline.
+func (m *MailYak) AttachInlineBytes(name string, data []byte) {
+	m.AttachInline(name, bytes.NewReader(append([]byte{}, data...)))
+}
+
+// AttachInlineFunc adds an inline attachment, referenced within the email body
+// by name as described in AttachInline, with the content read from the reader
+// returned by open.
+//
+// open is called each time the email is built, as described in AttachFunc.
+func (m *MailYak) AttachInlineFunc(name string, open func() (io.ReadCloser, error)) {
 	m.attachments = append(m.attachments, attachment{
 		filename: name,
-		content:  r,
 		inline:   true,
-		mimeType: mimeType,
+		open:     open,
 	})
 }
 
 // ClearAttachments removes all current attachments.
 func (m *MailYak) ClearAttachments() {
+	m.closeAttachments()
 	m.attachments = []attachment{}
 }
 
+// openAttachments opens the content of each attachment ahead of building the
+// email, so an attachment that cannot be read (such as a missing file, or a
+// reader that has already been sent) fails before any SMTP command is sent,
+// rather than part way through the DATA stream.
+func (m *MailYak) openAttachments() error {
+	m.closeAttachments()
+
+	opened := make([]io.ReadCloser, 0, len(m.attachments))
+	for _, a := range m.attachments {
+		r, err := a.reader()
+		if err != nil {
+			for _, rc := range opened {
+				_ = rc.Close()
+			}
+			return err
+		}
+		opened = append(opened, r)
+	}
+
+	m.opened = opened
+	return nil
+}
+
+// takeOpened returns the content of attachment i opened by openAttachments, or
+// nil if it has not been opened or has already been taken.
+func (m *MailYak) takeOpened(i int) io.ReadCloser {
+	if i >= len(m.opened) {
+		return nil
+	}
+	rc := m.opened[i]
+	m.opened[i] = nil
+	return rc
+}
+
+// closeAttachments closes any attachment content opened by openAttachments that
+// was not used to build the email.
+func (m *MailYak) closeAttachments() {
+	for _, rc := range m.opened {
+		if rc != nil {
+			_ = rc.Close()
+		}
+	}
+	m.opened = nil
+}
+
 // writeAttachments loops over the attachments, guesses their content-type and
 // writes the data as a line-broken base64 string (using the splitter mutator).
 func (m *MailYak) writeAttachments(mixed partCreator, splitter writeWrapper) error {
+	return m.writeAttachmentParts(mixed, splitter, m.attachments)
+}
+
+// writeAttachmentParts writes each of the attachments in items as a part
+// created by mixed, as described in writeAttachments.
+func (m *MailYak) writeAttachmentParts(mixed partCreator, splitter writeWrapper, items []attachment) error {
 	h := make([]byte, sniffLen)
 
-	for _, item := range m.attachments {
-		hLen, err := io.ReadFull(item.content, h)
-		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+	for _, item := range items {
+		if err := writeAttachmentPart(mixed, splitter, item, h); err != nil {
 			return err
 		}
+	}
 
-		if item.mimeType == "" {
-			item.mimeType = http.DetectContentType(h[:hLen])
-		}
+	return nil
+}
 
-		ctype := fmt.Sprintf("%s;\n\tfilename=%q; name=%q", item.mimeType, item.filename, item.filename)
+// writeAttachmentPart writes the attachment item as a part created by mixed,
+// using h as a buffer to detect the content type.
+func writeAttachmentPart(mixed partCreator, splitter writeWrapper, item attachment, h []byte) error {
+	r, err := item.reader()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
 
-		part, err := mixed.CreatePart(getMIMEHeader(item, ctype))
-		if err != nil {
-			return err
-		}
+	hLen, err := io.ReadFull(r, h)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return err
+	}
 
-		encoder := base64.NewEncoder(base64.StdEncoding, splitter.new(part))
-		if _, err := encoder.Write(h[:hLen]); err != nil {
-			return err
-		}
+	if item.mimeType == "" {
+		item.mimeType = http.DetectContentType(h[:hLen])
+	}
 
-		// More to write?
-		if hLen == sniffLen {
-			if _, err := io.Copy(encoder, item.content); err != nil {
-				return err
-			}
-		}
+	ctype := foldParams(item.mimeType, filenameParams(item.filename))
+
+	part, err := mixed.CreatePart(getMIMEHeader(item, ctype))
+	if err != nil {
+		return err
+	}
+
+	encoder := base64.NewEncoder(base64.StdEncoding, splitter.new(part))
+	if _, err := encoder.Write(h[:hLen]); err != nil {
+		return err
+	}
 
-		if err := encoder.Close(); err != nil {
+	// More to write?
+	if hLen == len(h) {
+		if _, err := io.Copy(encoder, r); err != nil {
 			return err
 		}
 	}
 
-	return nil
+	return encoder.Close()
 }
 
 func getMIMEHeader(a attachment, ctype string) textproto.MIMEHeader {
 	var disp string
 	var header textproto.MIMEHeader
 
-	cid := fmt.Sprintf("<%s>", a.filename)
+	cid := fmt.Sprintf("<%s>", ContentID(a.filename))
+	if len("Content-ID: ")+len(cid) > maxHeaderLineLen {
+		// The Content-ID cannot be split, so write it on a line of its own.
+		cid = "\r\n\t" + cid
+	}
+
 	if a.inline {
-		disp = fmt.Sprintf("inline;\n\tfilename=%q; name=%q", a.filename, a.filename)
+		disp = foldParams("inline", filenameParams(a.filename))
 		header = textproto.MIMEHeader{
 			"Content-Type":              {ctype},
 			"Content-Disposition":       {disp},
@@ -153,7 +361,7 @@ func getMIMEHeader(a attachment, ctype string) textproto.MIMEHeader {
 			"Content-ID":                {cid},
 		}
 	} else {
-		disp = fmt.Sprintf("attachment;\n\tfilename=%q; name=%q", a.filename, a.filename)
+		disp = foldParams("attachment", filenameParams(a.filename))
 		header = textproto.MIMEHeader{
 			"Content-Type":              {ctype},
 			"Content-Disposition":       {disp},
@@ -164,3 +372,134 @@ func getMIMEHeader(a attachment, ctype string) textproto.MIMEHeader {
 
 	return header
 }
+
+// maxParamSectionLen is the maximum length of each section of a RFC 2231
+// encoded parameter value.
+const maxParamSectionLen = 60
+
+// filenameParams returns the filename and name MIME parameters for filename.
+//
+// Short filenames containing only printable ASCII characters are written as
+// quoted strings. Other filenames are percent-encoded as UTF-8 in the filename
+// parameter (RFC 2231), split into continuations if long, with a RFC 2047
+// encoded name parameter for clients that do not support RFC 2231.
+func filenameParams(filename string) []string {
+	if isPrintableASCII(filename) && len(quoteParam(filename)) <= maxParamSectionLen {
+		return []string{"filename=" + quoteParam(filename), "name=" + quoteParam(filename)}
+	}
+
+	// Each encoded-word in the name is written on a separate line to keep
+	// the line length within limits.
+	name := strings.Replace(encodeWords(filename), " ", "\r\n\t", -1)
+
+	return append(rfc2231Param("filename", filename), "name="+quoteParam(name))
+}
+
+// encodeWords returns s as space separated RFC 2047 Q encoded-words, each at
+// most maxParamSectionLen characters long and without splitting a UTF-8
+// sequence across words.
+func encodeWords(s string) string {
+	const prefix, suffix = "=?UTF-8?q?", "?="
+
+	var (
+		words []string
+		word  strings.Builder
+	)
+	for len(s) > 0 {
+		_, n := utf8.DecodeRuneInString(s)
+
+		var enc strings.Builder
+		for _, c := range []byte(s[:n]) {
+			switch {
+			case c == ' ':
+				enc.WriteByte('_')
+			case c >= '!' && c <= '~' && c != '=' && c != '?' && c != '_':
+				enc.WriteByte(c)
+			default:
+				fmt.Fprintf(&enc, "=%02X", c)
+			}
+		}
+		s = s[n:]
+
+		if word.Len() > 0 && len(prefix)+word.Len()+enc.Len()+len(suffix) > maxParamSectionLen {
+			words = append(words, prefix+word.String()+suffix)
+			word.Reset()
+		}
+		word.WriteString(enc.String())
+	}
+	words = append(words, prefix+word.String()+suffix)
+
+	return strings.Join(words, " ")
+}
+
+// ContentID returns the Content-ID of an inline attachment named name, for
+// referencing it using the cid URL protocol.
+//
+// Names made of printable ASCII characters are used as-is. Other names cannot
+// be used in a Content-ID, and are replaced with a token derived from the name.
+func ContentID(name string) string {
+	if isPrintableASCII(name) {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:16])
+}
+
+// isPrintableASCII returns true if s contains only printable ASCII
+// characters.
+func isPrintableASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// quoteParam returns s as a quoted-string (RFC 2045), escaping any quotes and
+// backslashes.
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// rfc2231Param returns the parameter name with value encoded as UTF-8
+// according to RFC 2231, split into numbered continuations of at most
+// maxParamSectionLen characters if required.
+func rfc2231Param(name, value string) []string {
+	var sections []string
+	section := "UTF-8''"
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		enc := string(c)
+		if !isAttributeChar(c) {
+			enc = fmt.Sprintf("%%%02X", c)
+		}
+
+		if len(section)+len(enc) > maxParamSectionLen {
+			sections = append(sections, section)
+			section = ""
+		}
+		section += enc
+	}
+	sections = append(sections, section)
+
+	if len(sections) == 1 {
+		return []string{name + "*=" + sections[0]}
+	}
+
+	params := make([]string, 0, len(sections))
+	for i, s := range sections {
+		params = append(params, fmt.Sprintf("%s*%d*=%s", name, i, s))
+	}
+	return params
+}
+
+// isAttributeChar returns true if c can appear unencoded in a RFC 2231
+// extended parameter value.
+func isAttributeChar(c byte) bool {
+	if c <= ' ' || c >= 0x7f {
+		return false
+	}
+	return !strings.ContainsRune(`*'%()<>@,;:\"/[]?=`, rune(c))
+}
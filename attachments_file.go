@@ -0,0 +1,80 @@
+package mailyak
+
+import (
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// AttachFile adds the file at filePath to the email as an attachment, using
+// the base name of filePath as the attachment filename:
+//
+//	mail.AttachFile("/path/to/report.pdf")
+//
+// The file is not opened until the email is sent, and is closed once it has
+// been read, so an email can be sent repeatedly without holding the file open.
+// An error opening the file is returned by Send(), before connecting to the SMTP
+// server.
+//
+// The MIME type is determined from the file extension using
+// mime.TypeByExtension, falling back to detecting it from the file content
+// using https://golang.org/pkg/net/http/#DetectContentType
+func (m *MailYak) AttachFile(filePath string) {
+	m.attachments = append(m.attachments, fileAttachment(filepath.Base(filePath), false, func() (io.ReadCloser, error) {
+		return os.Open(filePath)
+	}))
+}
+
+// AttachInlineFile adds the file at filePath to the email as an inline
+// attachment, using the base name of filePath as the attachment filename.
+//
+// The file is referenced within the email body by its base name as described
+// in AttachInline, and is opened and the MIME type determined as described in
+// AttachFile.
+func (m *MailYak) AttachInlineFile(filePath string) {
+	m.attachments = append(m.attachments, fileAttachment(filepath.Base(filePath), true, func() (io.ReadCloser, error) {
+		return os.Open(filePath)
+	}))
+}
+
+// AttachFS adds the file name in fsys to the email as an attachment, using the
+// base name of name as the attachment filename:
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	mail.AttachFS(templates, "templates/terms.pdf")
+//
+// The file is opened and the MIME type determined as described in AttachFile.
+func (m *MailYak) AttachFS(fsys fs.FS, name string) {
+	m.attachments = append(m.attachments, fileAttachment(path.Base(name), false, func() (io.ReadCloser, error) {
+		return fsys.Open(name)
+	}))
+}
+
+// AttachInlineFS adds the file name in fsys to the email as an inline
+// attachment, using the base name of name as the attachment filename.
+//
+// The file is referenced within the email body by its base name as described
+// in AttachInline, and is opened and the MIME type determined as described in
+// AttachFile.
+func (m *MailYak) AttachInlineFS(fsys fs.FS, name string) {
+	m.attachments = append(m.attachments, fileAttachment(path.Base(name), true, func() (io.ReadCloser, error) {
+		return fsys.Open(name)
+	}))
+}
+
+// fileAttachment returns an attachment named filename with content read from
+// the file returned by open, and the MIME type determined from the extension
+// of filename if known.
+func fileAttachment(filename string, inline bool, open func() (io.ReadCloser, error)) attachment {
+	return attachment{
+		filename: filename,
+		inline:   inline,
+		mimeType: mime.TypeByExtension(path.Ext(filename)),
+		open:     open,
+	}
+}
@@ -0,0 +1,146 @@
+package mailyak
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// TestMailYakAttachFile ensures files are attached with their base name and
+// the MIME type of their extension, sniffing the content type of unknown
+// extensions.
+func TestMailYakAttachFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"report.pdf":  "%PDF-1.4 not really",
+		"logo.png":    "fake image",
+		"notes.xyzzy": "<html><head></head></html>",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fsys := fstest.MapFS{
+		"templates/terms.txt": {Data: []byte("terms")},
+		"templates/icon.gif":  {Data: []byte("GIF89a")},
+	}
+
+	m := New("mail.host.com:25", nil)
+	m.Plain().Set("Body")
+	m.AttachFile(filepath.Join(dir, "report.pdf"))
+	m.AttachFile(filepath.Join(dir, "notes.xyzzy"))
+	m.AttachInlineFile(filepath.Join(dir, "logo.png"))
+	m.AttachFS(fsys, "templates/terms.txt")
+	m.AttachInlineFS(fsys, "templates/icon.gif")
+
+	tests := []struct {
+		name     string
+		inline   bool
+		mimeType string
+		content  string
+	}{
+		{"report.pdf", false, "application/pdf", "%PDF-1.4 not really"},
+		{"notes.xyzzy", false, "text/html; charset=utf-8", "<html><head></head></html>"},
+		{"logo.png", true, "image/png", "fake image"},
+		{"terms.txt", false, "text/plain; charset=utf-8", "terms"},
+		{"icon.gif", true, "image/gif", "GIF89a"},
+	}
+
+	if len(m.attachments) != len(tests) {
+		t.Fatalf("got %d attachments, want %d", len(m.attachments), len(tests))
+	}
+
+	// Build the email twice to ensure the files are re-opened.
+	for i := 0; i < 2; i++ {
+		buf, err := m.MimeBuf()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range tests {
+			ctype := "Content-Type: " + tt.mimeType + ";\r\n\tfilename=\"" + tt.name + "\""
+			if !strings.Contains(buf.String(), ctype) {
+				t.Errorf("email missing %q", ctype)
+			}
+
+			encoded := base64.StdEncoding.EncodeToString([]byte(tt.content))
+			if !strings.Contains(buf.String(), encoded) {
+				t.Errorf("email missing content of %q", tt.name)
+			}
+		}
+	}
+
+	for i, tt := range tests {
+		if got := m.attachments[i]; got.filename != tt.name || got.inline != tt.inline {
+			t.Errorf("attachment %d = {%q, %v}, want {%q, %v}", i, got.filename, got.inline, tt.name, tt.inline)
+		}
+	}
+}
+
+// TestMailYakAttachFile_missing ensures an error opening a file is returned
+// when the email is built.
+func TestMailYakAttachFile_missing(t *testing.T) {
+	t.Parallel()
+
+	m := New("mail.host.com:25", nil)
+	m.AttachFile(filepath.Join(t.TempDir(), "missing.txt"))
+
+	if _, err := m.MimeBuf(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	m.ClearAttachments()
+	m.AttachFS(fstest.MapFS{}, "missing.txt")
+
+	if _, err := m.MimeBuf(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+// TestMailYakAttachFile_missingSend ensures a missing file fails the send
+// before connecting to the SMTP server, so no partial email is delivered.
+func TestMailYakAttachFile_missingSend(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	accepted := make(chan struct{})
+	go func() {
+		conn, err := socket.Accept()
+		if err != nil {
+			return
+		}
+		conn.Close()
+		close(accepted)
+	}()
+
+	m := New(socket.Addr().String(), nil)
+	m.From("from@example.org")
+	m.To("to@example.org")
+	m.Plain().Set(strings.Repeat("bananas ", 1024))
+	m.AttachFile(filepath.Join(t.TempDir(), "missing.txt"))
+
+	if err := m.Send(); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v, want %v", err, fs.ErrNotExist)
+	}
+
+	select {
+	case <-accepted:
+		t.Fatal("connected to the SMTP server")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
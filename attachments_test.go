@@ -2,11 +2,16 @@ package mailyak
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
 	"io"
+	"io/ioutil"
+	"mime"
 	"net/textproto"
 	"strings"
 	"testing"
+	"time"
 )
 
 type testAttachment struct {
@@ -71,7 +76,7 @@ func TestMailYakAttach(t *testing.T) {
 		},
 		{
 			"From one",
-			[]attachment{{"Existing", &bytes.Buffer{}, false, ""}},
+			[]attachment{{"Existing", &bytes.Buffer{}, false, "", nil}},
 			"test",
 			&bytes.Buffer{},
 			2,
@@ -117,7 +122,7 @@ func TestMailYakAttachInline(t *testing.T) {
 		},
 		{
 			"From one",
-			[]attachment{{"Existing", &bytes.Buffer{}, false, ""}},
+			[]attachment{{"Existing", &bytes.Buffer{}, false, "", nil}},
 			"test",
 			&bytes.Buffer{},
 			2,
@@ -165,7 +170,7 @@ func TestMailYakAttachWithMimeType(t *testing.T) {
 		},
 		{
 			"From one",
-			[]attachment{{"Existing", &bytes.Buffer{}, false, "text/csv; charset=utf-8"}},
+			[]attachment{{"Existing", &bytes.Buffer{}, false, "text/csv; charset=utf-8", nil}},
 			"test",
 			&bytes.Buffer{},
 			"text/csv; charset=utf-8",
@@ -214,7 +219,7 @@ func TestMailYakAttachInlineWithMimeType(t *testing.T) {
 		},
 		{
 			"From one",
-			[]attachment{{"Existing", &bytes.Buffer{}, false, "text/csv; charset=utf-8"}},
+			[]attachment{{"Existing", &bytes.Buffer{}, false, "text/csv; charset=utf-8", nil}},
 			"test",
 			&bytes.Buffer{},
 			"text/csv; charset=utf-8",
@@ -236,6 +241,152 @@ func TestMailYakAttachInlineWithMimeType(t *testing.T) {
 	}
 }
 
+// countingCloser records the number of times it is closed.
+type countingCloser struct {
+	io.Reader
+	closed *int
+}
+
+func (c countingCloser) Close() error {
+	*c.closed++
+	return nil
+}
+
+// TestMailYakAttach_reusable ensures attachments are written in full each time
+// the email is built.
+func TestMailYakAttach_reusable(t *testing.T) {
+	t.Parallel()
+
+	var opened, closed int
+
+	m := New("mail.host.com:25", nil)
+	m.SetMessageID("id@itsallbroken.com")
+	m.SetClock(func() time.Time { return time.Unix(0, 0) })
+	m.SetBoundaryGenerator(func() (string, error) { return "boundary", nil })
+	m.Plain().Set("Body")
+	m.Attach("seeker.txt", strings.NewReader("seeker content"))
+	m.AttachBytes("bytes.txt", []byte("bytes content"))
+	m.AttachInlineBytes("inline.txt", []byte("inline content"))
+	m.AttachFunc("func.txt", func() (io.ReadCloser, error) {
+		opened++
+		return countingCloser{Reader: strings.NewReader("func content"), closed: &closed}, nil
+	})
+
+	// Partially read seekers are rewound to their original offset.
+	partial := strings.NewReader("skipped partial content")
+	if _, err := partial.Seek(int64(len("skipped ")), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	m.AttachInline("partial.txt", partial)
+
+	first, err := m.MimeBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, content := range []string{"seeker content", "bytes content", "inline content", "func content", "partial content"} {
+		encoded := base64.StdEncoding.EncodeToString([]byte(content))
+		if !strings.Contains(first.String(), encoded) {
+			t.Errorf("email missing attachment %q", content)
+		}
+	}
+
+	second, err := m.MimeBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Errorf("second email differs:\ngot:  %q\nwant: %q", second, first)
+	}
+	if opened != 2 || closed != 2 {
+		t.Errorf("opened %d and closed %d times, want 2", opened, closed)
+	}
+}
+
+// TestMailYakAttach_oneShot ensures building an email a second time with an
+// attachment read from a non-seekable reader returns an error, rather than
+// sending an empty attachment.
+func TestMailYakAttach_oneShot(t *testing.T) {
+	t.Parallel()
+
+	m := New("mail.host.com:25", nil)
+	m.Plain().Set("Body")
+	m.Attach("pipe.txt", struct{ io.Reader }{strings.NewReader("pipe content")})
+
+	buf, err := m.MimeBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoded := base64.StdEncoding.EncodeToString([]byte("pipe content")); !strings.Contains(buf.String(), encoded) {
+		t.Error("email missing attachment")
+	}
+
+	if _, err := m.MimeBuf(); !errors.Is(err, ErrAttachmentConsumed) {
+		t.Fatalf("got error %v, want %v", err, ErrAttachmentConsumed)
+	}
+}
+
+// TestMailYakAttach_oneShotSend ensures a consumed reader fails the send before
+// any SMTP command is sent, and that a send failing before the email is built
+// does not consume the reader.
+func TestMailYakAttach_oneShotSend(t *testing.T) {
+	t.Parallel()
+
+	var calls int
+	send := func(ctx context.Context, m SendableMail) error {
+		calls++
+		if calls == 1 {
+			// Fail before writing the email, such as a rejected recipient.
+			return errors.New("recipient rejected")
+		}
+		return m.WriteMime(ioutil.Discard)
+	}
+
+	m := New("mail.host.com:25", nil)
+	m.UseSender(senderFunc(send))
+	m.Plain().Set("Body")
+	m.Attach("pipe.txt", struct{ io.Reader }{strings.NewReader("pipe content")})
+
+	if err := m.Send(); err == nil {
+		t.Fatal("expected error")
+	}
+	if err := m.Send(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Send(); !errors.Is(err, ErrAttachmentConsumed) {
+		t.Fatalf("got error %v, want %v", err, ErrAttachmentConsumed)
+	}
+	if calls != 2 {
+		t.Errorf("sender called %d times, want 2", calls)
+	}
+}
+
+// senderFunc is a Sender calling the func.
+type senderFunc func(ctx context.Context, m SendableMail) error
+
+func (f senderFunc) Send(ctx context.Context, m SendableMail) error {
+	return f(ctx, m)
+}
+
+// TestMailYakAttachFunc_error ensures errors opening an attachment are
+// returned.
+func TestMailYakAttachFunc_error(t *testing.T) {
+	t.Parallel()
+
+	wantErr := errors.New("no such file")
+
+	m := New("mail.host.com:25", nil)
+	m.AttachFunc("missing.txt", func() (io.ReadCloser, error) {
+		return nil, wantErr
+	})
+
+	if _, err := m.MimeBuf(); !errors.Is(err, wantErr) {
+		t.Fatalf("got %v, want %v", err, wantErr)
+	}
+}
+
 // TestMailYakWriteAttachments ensures the correct headers are wrote, and the
 // data is base64 encoded correctly
 func TestMailYakWriteAttachments(t *testing.T) {
@@ -254,33 +405,33 @@ func TestMailYakWriteAttachments(t *testing.T) {
 	}{
 		{
 			"Empty",
-			[]attachment{{"Empty", &bytes.Buffer{}, false, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"Empty\"; name=\"Empty\"",
-			"attachment;\n\tfilename=\"Empty\"; name=\"Empty\"",
+			[]attachment{{"Empty", &bytes.Buffer{}, false, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename=\"Empty\"; name=\"Empty\"",
+			"attachment;\r\n\tfilename=\"Empty\"; name=\"Empty\"",
 			"",
 			false,
 		},
 		{
 			"Short string",
-			[]attachment{{"advice", strings.NewReader("Don't Panic"), false, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"advice\"; name=\"advice\"",
-			"attachment;\n\tfilename=\"advice\"; name=\"advice\"",
+			[]attachment{{"advice", strings.NewReader("Don't Panic"), false, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename=\"advice\"; name=\"advice\"",
+			"attachment;\r\n\tfilename=\"advice\"; name=\"advice\"",
 			"RG9uJ3QgUGFuaWM=",
 			false,
 		},
 		{
 			"Space in filename",
-			[]attachment{{"Empty with spaces", &bytes.Buffer{}, false, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"Empty with spaces\"; name=\"Empty with spaces\"",
-			"attachment;\n\tfilename=\"Empty with spaces\"; name=\"Empty with spaces\"",
+			[]attachment{{"Empty with spaces", &bytes.Buffer{}, false, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename=\"Empty with spaces\"; name=\"Empty with spaces\"",
+			"attachment;\r\n\tfilename=\"Empty with spaces\"; name=\"Empty with spaces\"",
 			"",
 			false,
 		},
 		{
 			"With specified MIME type",
-			[]attachment{{"Empty with spaces", &bytes.Buffer{}, false, "text/csv; charset=utf-8"}},
-			"text/csv; charset=utf-8;\n\tfilename=\"Empty with spaces\"; name=\"Empty with spaces\"",
-			"attachment;\n\tfilename=\"Empty with spaces\"; name=\"Empty with spaces\"",
+			[]attachment{{"Empty with spaces", &bytes.Buffer{}, false, "text/csv; charset=utf-8", nil}},
+			"text/csv; charset=utf-8;\r\n\tfilename=\"Empty with spaces\"; name=\"Empty with spaces\"",
+			"attachment;\r\n\tfilename=\"Empty with spaces\"; name=\"Empty with spaces\"",
 			"",
 			false,
 		},
@@ -295,10 +446,11 @@ func TestMailYakWriteAttachments(t *testing.T) {
 					),
 					false,
 					"",
+					nil,
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"partyinvite.txt\"; name=\"partyinvite.txt\"",
-			"attachment;\n\tfilename=\"partyinvite.txt\"; name=\"partyinvite.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"partyinvite.txt\"; name=\"partyinvite.txt\"",
+			"attachment;\r\n\tfilename=\"partyinvite.txt\"; name=\"partyinvite.txt\"",
 			"SWYgQmFsZHJpY2sgc2VydmVkIGEgbWVhbCBhdCBIUSBoZSB3b3VsZCBiZSBhcnJlc3Rl" +
 				"ZCBmb3IgdGhlIGJpZ2dlc3QgbWFzcyBwb2lzb25pbmcgc2luY2UgTHVjcmV0aWEgQm9y" +
 				"Z2lhIGludml0ZWQgNTAwIGZyaWVuZHMgZm9yIGEgV2luZSBhbmQgQW50aHJheCBQYXJ0eS4=",
@@ -323,10 +475,11 @@ func TestMailYakWriteAttachments(t *testing.T) {
 					),
 					false,
 					"",
+					nil,
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
-			"attachment;\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
+			"attachment;\r\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
 			"Tm93IGl0IGlzIHN1Y2ggYSBiaXphcnJlbHkgaW1wcm9iYWJsZSBjb2luY2lkZW5jZSB0a" +
 				"GF0IGFueXRoaW5nIHNvIG1pbmQtYm9nZ2xpbmdseSB1c2VmdWwgY291bGQgaGF2ZSBldm" +
 				"9sdmVkIHB1cmVseSBieSBjaGFuY2UgdGhhdCBzb21lIHRoaW5rZXJzIGhhdmUgY2hvc2V" +
@@ -346,17 +499,17 @@ func TestMailYakWriteAttachments(t *testing.T) {
 		},
 		{
 			"HTML",
-			[]attachment{{"name.html", strings.NewReader("<html><head></head></html>"), false, ""}},
-			"text/html; charset=utf-8;\n\tfilename=\"name.html\"; name=\"name.html\"",
-			"attachment;\n\tfilename=\"name.html\"; name=\"name.html\"",
+			[]attachment{{"name.html", strings.NewReader("<html><head></head></html>"), false, "", nil}},
+			"text/html; charset=utf-8;\r\n\tfilename=\"name.html\"; name=\"name.html\"",
+			"attachment;\r\n\tfilename=\"name.html\"; name=\"name.html\"",
 			"PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4=",
 			false,
 		},
 		{
 			"HTML - wrong extension",
-			[]attachment{{"name.png", strings.NewReader("<html><head></head></html>"), false, ""}},
-			"text/html; charset=utf-8;\n\tfilename=\"name.png\"; name=\"name.png\"",
-			"attachment;\n\tfilename=\"name.png\"; name=\"name.png\"",
+			[]attachment{{"name.png", strings.NewReader("<html><head></head></html>"), false, "", nil}},
+			"text/html; charset=utf-8;\r\n\tfilename=\"name.png\"; name=\"name.png\"",
+			"attachment;\r\n\tfilename=\"name.png\"; name=\"name.png\"",
 			"PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4=",
 			false,
 		},
@@ -364,17 +517,17 @@ func TestMailYakWriteAttachments(t *testing.T) {
 		// inline attachments
 		{
 			"Empty inline",
-			[]attachment{{"Empty", &bytes.Buffer{}, true, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"Empty\"; name=\"Empty\"",
-			"inline;\n\tfilename=\"Empty\"; name=\"Empty\"",
+			[]attachment{{"Empty", &bytes.Buffer{}, true, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename=\"Empty\"; name=\"Empty\"",
+			"inline;\r\n\tfilename=\"Empty\"; name=\"Empty\"",
 			"",
 			false,
 		},
 		{
 			"Short string inline",
-			[]attachment{{"advice", strings.NewReader("Don't Panic"), true, ""}},
-			"text/plain; charset=utf-8;\n\tfilename=\"advice\"; name=\"advice\"",
-			"inline;\n\tfilename=\"advice\"; name=\"advice\"",
+			[]attachment{{"advice", strings.NewReader("Don't Panic"), true, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename=\"advice\"; name=\"advice\"",
+			"inline;\r\n\tfilename=\"advice\"; name=\"advice\"",
 			"RG9uJ3QgUGFuaWM=",
 			false,
 		},
@@ -389,10 +542,11 @@ func TestMailYakWriteAttachments(t *testing.T) {
 					),
 					true,
 					"",
+					nil,
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"partyinvite.txt\"; name=\"partyinvite.txt\"",
-			"inline;\n\tfilename=\"partyinvite.txt\"; name=\"partyinvite.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"partyinvite.txt\"; name=\"partyinvite.txt\"",
+			"inline;\r\n\tfilename=\"partyinvite.txt\"; name=\"partyinvite.txt\"",
 			"SWYgQmFsZHJpY2sgc2VydmVkIGEgbWVhbCBhdCBIUSBoZSB3b3VsZCBiZSBhcnJlc3Rl" +
 				"ZCBmb3IgdGhlIGJpZ2dlc3QgbWFzcyBwb2lzb25pbmcgc2luY2UgTHVjcmV0aWEgQm9y" +
 				"Z2lhIGludml0ZWQgNTAwIGZyaWVuZHMgZm9yIGEgV2luZSBhbmQgQW50aHJheCBQYXJ0eS4=",
@@ -417,10 +571,11 @@ func TestMailYakWriteAttachments(t *testing.T) {
 					),
 					true,
 					"",
+					nil,
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
-			"inline;\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
+			"inline;\r\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
 			"Tm93IGl0IGlzIHN1Y2ggYSBiaXphcnJlbHkgaW1wcm9iYWJsZSBjb2luY2lkZW5jZSB0a" +
 				"GF0IGFueXRoaW5nIHNvIG1pbmQtYm9nZ2xpbmdseSB1c2VmdWwgY291bGQgaGF2ZSBldm" +
 				"9sdmVkIHB1cmVseSBieSBjaGFuY2UgdGhhdCBzb21lIHRoaW5rZXJzIGhhdmUgY2hvc2V" +
@@ -440,20 +595,52 @@ func TestMailYakWriteAttachments(t *testing.T) {
 		},
 		{
 			"HTML inline",
-			[]attachment{{"name.html", strings.NewReader("<html><head></head></html>"), true, ""}},
-			"text/html; charset=utf-8;\n\tfilename=\"name.html\"; name=\"name.html\"",
-			"inline;\n\tfilename=\"name.html\"; name=\"name.html\"",
+			[]attachment{{"name.html", strings.NewReader("<html><head></head></html>"), true, "", nil}},
+			"text/html; charset=utf-8;\r\n\tfilename=\"name.html\"; name=\"name.html\"",
+			"inline;\r\n\tfilename=\"name.html\"; name=\"name.html\"",
 			"PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4=",
 			false,
 		},
 		{
 			"HTML - wrong extension inline",
-			[]attachment{{"name.png", strings.NewReader("<html><head></head></html>"), true, ""}},
-			"text/html; charset=utf-8;\n\tfilename=\"name.png\"; name=\"name.png\"",
-			"inline;\n\tfilename=\"name.png\"; name=\"name.png\"",
+			[]attachment{{"name.png", strings.NewReader("<html><head></head></html>"), true, "", nil}},
+			"text/html; charset=utf-8;\r\n\tfilename=\"name.png\"; name=\"name.png\"",
+			"inline;\r\n\tfilename=\"name.png\"; name=\"name.png\"",
 			"PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4=",
 			false,
 		},
+		{
+			"Non-ASCII filename",
+			[]attachment{{"Rechnung_März.txt", strings.NewReader("Hello"), false, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename*=UTF-8''Rechnung_M%C3%A4rz.txt;\r\n\tname=\"=?UTF-8?q?Rechnung=5FM=C3=A4rz.txt?=\"",
+			"attachment;\r\n\tfilename*=UTF-8''Rechnung_M%C3%A4rz.txt;\r\n\tname=\"=?UTF-8?q?Rechnung=5FM=C3=A4rz.txt?=\"",
+			"SGVsbG8=",
+			false,
+		},
+		{
+			"Filename with quotes",
+			[]attachment{{`my "quoted" \ file.txt`, strings.NewReader("Hello"), false, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename=\"my \\\"quoted\\\" \\\\ file.txt\"; name=\"my \\\"quoted\\\" \\\\ file.txt\"",
+			"attachment;\r\n\tfilename=\"my \\\"quoted\\\" \\\\ file.txt\"; name=\"my \\\"quoted\\\" \\\\ file.txt\"",
+			"SGVsbG8=",
+			false,
+		},
+		{
+			"Long non-ASCII filename",
+			[]attachment{{"Ein sehr langer Dateiname für die Übersicht der Jahresabrechnung 2024.txt", strings.NewReader("Hello"), true, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename*0*=UTF-8''Ein%20sehr%20langer%20Dateiname%20f%C3%BCr%20die%20;\r\n\tfilename*1*=%C3%9Cbersicht%20der%20Jahresabrechnung%202024.txt;\r\n\tname=\"=?UTF-8?q?Ein_sehr_langer_Dateiname_f=C3=BCr_die_=C3=9Cber?=\r\n\t=?UTF-8?q?sicht_der_Jahresabrechnung_2024.txt?=\"",
+			"inline;\r\n\tfilename*0*=UTF-8''Ein%20sehr%20langer%20Dateiname%20f%C3%BCr%20die%20;\r\n\tfilename*1*=%C3%9Cbersicht%20der%20Jahresabrechnung%202024.txt;\r\n\tname=\"=?UTF-8?q?Ein_sehr_langer_Dateiname_f=C3=BCr_die_=C3=9Cber?=\r\n\t=?UTF-8?q?sicht_der_Jahresabrechnung_2024.txt?=\"",
+			"SGVsbG8=",
+			false,
+		},
+		{
+			"Long ASCII filename",
+			[]attachment{{"Quarterly report for the finance department - final revision.pdf", strings.NewReader("Hello"), false, "", nil}},
+			"text/plain; charset=utf-8;\r\n\tfilename*0*=UTF-8''Quarterly%20report%20for%20the%20finance%20department;\r\n\tfilename*1*=%20-%20final%20revision.pdf;\r\n\tname=\"=?UTF-8?q?Quarterly_report_for_the_finance_department_-_fi?=\r\n\t=?UTF-8?q?nal_revision.pdf?=\"",
+			"attachment;\r\n\tfilename*0*=UTF-8''Quarterly%20report%20for%20the%20finance%20department;\r\n\tfilename*1*=%20-%20final%20revision.pdf;\r\n\tname=\"=?UTF-8?q?Quarterly_report_for_the_finance_department_-_fi?=\r\n\t=?UTF-8?q?nal_revision.pdf?=\"",
+			"SGVsbG8=",
+			false,
+		},
 		{
 			"String >512 characters (read full buffer)",
 			[]attachment{
@@ -478,10 +665,11 @@ func TestMailYakWriteAttachments(t *testing.T) {
 					)),
 					false,
 					"",
+					nil,
 				},
 			},
-			"text/plain; charset=utf-8;\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
-			"attachment;\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
+			"text/plain; charset=utf-8;\r\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
+			"attachment;\r\n\tfilename=\"qed.txt\"; name=\"qed.txt\"",
 			"Tm93IGl0IGlzIHN1Y2ggYSBiaXphcnJlbHkgaW1wcm9iYWJsZSBjb2luY2lkZW5jZSB0a" +
 				"GF0IGFueXRoaW5nIHNvIG1pbmQtYm9nZ2xpbmdseSB1c2VmdWwgY291bGQgaGF2ZSBldm" +
 				"9sdmVkIHB1cmVseSBieSBjaGFuY2UgdGhhdCBzb21lIHRoaW5rZXJzIGhhdmUgY2hvc2V" +
@@ -548,11 +736,11 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 	}{
 		{
 			"Single Attachment",
-			[]attachment{{"name.txt", strings.NewReader("test"), false, ""}},
+			[]attachment{{"name.txt", strings.NewReader("test"), false, "", nil}},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 			},
@@ -560,11 +748,11 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		},
 		{
 			"Single Attachment with specified MIME type",
-			[]attachment{{"name.txt", strings.NewReader("test"), false, "text/csv; charset=utf-8"}},
+			[]attachment{{"name.txt", strings.NewReader("test"), false, "text/csv; charset=utf-8", nil}},
 			[]testAttachment{
 				{
-					contentType: "text/csv; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/csv; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 			},
@@ -573,18 +761,18 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		{
 			"Multiple Attachment - same types",
 			[]attachment{
-				{"name.txt", strings.NewReader("test"), false, ""},
-				{"different.txt", strings.NewReader("another"), false, ""},
+				{"name.txt", strings.NewReader("test"), false, "", nil},
+				{"different.txt", strings.NewReader("another"), false, "", nil},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"different.txt\"; name=\"different.txt\"",
-					disposition: "attachment;\n\tfilename=\"different.txt\"; name=\"different.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"different.txt\"; name=\"different.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"different.txt\"; name=\"different.txt\"",
 					data:        *bytes.NewBufferString("YW5vdGhlcg=="),
 				},
 			},
@@ -593,18 +781,18 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		{
 			"Multiple Attachment - different types",
 			[]attachment{
-				{"name.txt", strings.NewReader("test"), false, ""},
-				{"html.txt", strings.NewReader("<html><head></head></html>"), false, ""},
+				{"name.txt", strings.NewReader("test"), false, "", nil},
+				{"html.txt", strings.NewReader("<html><head></head></html>"), false, "", nil},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/html; charset=utf-8;\n\tfilename=\"html.txt\"; name=\"html.txt\"",
-					disposition: "attachment;\n\tfilename=\"html.txt\"; name=\"html.txt\"",
+					contentType: "text/html; charset=utf-8;\r\n\tfilename=\"html.txt\"; name=\"html.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"html.txt\"; name=\"html.txt\"",
 					data:        *bytes.NewBufferString("PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4="),
 				},
 			},
@@ -613,18 +801,18 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		{
 			"Multiple Attachment - different specified MIME types",
 			[]attachment{
-				{"name.txt", strings.NewReader("test"), false, "text/csv; charset=utf-8"},
-				{"html.txt", strings.NewReader("<html><head></head></html>"), false, "application/xml"},
+				{"name.txt", strings.NewReader("test"), false, "text/csv; charset=utf-8", nil},
+				{"html.txt", strings.NewReader("<html><head></head></html>"), false, "application/xml", nil},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/csv; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/csv; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "application/xml;\n\tfilename=\"html.txt\"; name=\"html.txt\"",
-					disposition: "attachment;\n\tfilename=\"html.txt\"; name=\"html.txt\"",
+					contentType: "application/xml;\r\n\tfilename=\"html.txt\"; name=\"html.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"html.txt\"; name=\"html.txt\"",
 					data:        *bytes.NewBufferString("PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4="),
 				},
 			},
@@ -646,6 +834,7 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 					false,
 					"",
+					nil,
 				},
 				{
 					"520.txt", strings.NewReader(
@@ -658,12 +847,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 					false,
 					"",
+					nil,
 				},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"550.txt\"; name=\"550.txt\"",
-					disposition: "attachment;\n\tfilename=\"550.txt\"; name=\"550.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"550.txt\"; name=\"550.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"550.txt\"; name=\"550.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gTWF1cmlzIHV0IG5pc" +
 							"2wgZmVsaXMuIEFlbmVhbiBmZWxpcyBqdXN0bywgZ3JhdmlkYSBlZ2V0IGxlbyBhbGlxdWV0LCBtb2xlc3RpZSBhbGlxdW" +
@@ -676,8 +866,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"520.txt\"; name=\"520.txt\"",
-					disposition: "attachment;\n\tfilename=\"520.txt\"; name=\"520.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"520.txt\"; name=\"520.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"520.txt\"; name=\"520.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gRG9uZWMgZXUgdmVz" +
 							"dGlidWx1bSBkb2xvci4gTnVuYyBhYyBwb3N1ZXJlIGZlbGlzLCBhIG1hdHRpcyBsZW8uIER1aXMgZWxlbWVudHVtIHRl" +
@@ -707,6 +897,7 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 					false,
 					"",
+					nil,
 				},
 				{
 					"550.txt",
@@ -721,12 +912,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 					false,
 					"",
+					nil,
 				},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"520.txt\"; name=\"520.txt\"",
-					disposition: "attachment;\n\tfilename=\"520.txt\"; name=\"520.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"520.txt\"; name=\"520.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"520.txt\"; name=\"520.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gRG9uZWMgZXUgdmVz" +
 							"dGlidWx1bSBkb2xvci4gTnVuYyBhYyBwb3N1ZXJlIGZlbGlzLCBhIG1hdHRpcyBsZW8uIER1aXMgZWxlbWVudHVtIHRl" +
@@ -739,8 +931,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"550.txt\"; name=\"550.txt\"",
-					disposition: "attachment;\n\tfilename=\"550.txt\"; name=\"550.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"550.txt\"; name=\"550.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"550.txt\"; name=\"550.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gTWF1cmlzIHV0IG5p" +
 							"c2wgZmVsaXMuIEFlbmVhbiBmZWxpcyBqdXN0bywgZ3JhdmlkYSBlZ2V0IGxlbyBhbGlxdWV0LCBtb2xlc3RpZSBhbGlx" +
@@ -759,11 +951,11 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		// inline attachments
 		{
 			"Single Inline Attachment",
-			[]attachment{{"name.txt", strings.NewReader("test"), true, ""}},
+			[]attachment{{"name.txt", strings.NewReader("test"), true, "", nil}},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 			},
@@ -771,11 +963,11 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		},
 		{
 			"Single Inline Attachment with specified MIME type",
-			[]attachment{{"name.txt", strings.NewReader("test"), true, "text/csv; charset=utf-8"}},
+			[]attachment{{"name.txt", strings.NewReader("test"), true, "text/csv; charset=utf-8", nil}},
 			[]testAttachment{
 				{
-					contentType: "text/csv; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/csv; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 			},
@@ -784,18 +976,18 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		{
 			"Multiple Inline Attachments - same types",
 			[]attachment{
-				{"name.txt", strings.NewReader("test"), true, ""},
-				{"different.txt", strings.NewReader("another"), true, ""},
+				{"name.txt", strings.NewReader("test"), true, "", nil},
+				{"different.txt", strings.NewReader("another"), true, "", nil},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"different.txt\"; name=\"different.txt\"",
-					disposition: "inline;\n\tfilename=\"different.txt\"; name=\"different.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"different.txt\"; name=\"different.txt\"",
+					disposition: "inline;\r\n\tfilename=\"different.txt\"; name=\"different.txt\"",
 					data:        *bytes.NewBufferString("YW5vdGhlcg=="),
 				},
 			},
@@ -804,18 +996,18 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		{
 			"Multiple Attachments - One Inline, One not",
 			[]attachment{
-				{"name.txt", strings.NewReader("test"), false, ""},
-				{"different.txt", strings.NewReader("another"), true, ""},
+				{"name.txt", strings.NewReader("test"), false, "", nil},
+				{"different.txt", strings.NewReader("another"), true, "", nil},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "attachment;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "attachment;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"different.txt\"; name=\"different.txt\"",
-					disposition: "inline;\n\tfilename=\"different.txt\"; name=\"different.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"different.txt\"; name=\"different.txt\"",
+					disposition: "inline;\r\n\tfilename=\"different.txt\"; name=\"different.txt\"",
 					data:        *bytes.NewBufferString("YW5vdGhlcg=="),
 				},
 			},
@@ -824,18 +1016,18 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		{
 			"Multiple Inline Attachments - different types",
 			[]attachment{
-				{"name.txt", strings.NewReader("test"), true, ""},
-				{"html.txt", strings.NewReader("<html><head></head></html>"), true, ""},
+				{"name.txt", strings.NewReader("test"), true, "", nil},
+				{"html.txt", strings.NewReader("<html><head></head></html>"), true, "", nil},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "text/html; charset=utf-8;\n\tfilename=\"html.txt\"; name=\"html.txt\"",
-					disposition: "inline;\n\tfilename=\"html.txt\"; name=\"html.txt\"",
+					contentType: "text/html; charset=utf-8;\r\n\tfilename=\"html.txt\"; name=\"html.txt\"",
+					disposition: "inline;\r\n\tfilename=\"html.txt\"; name=\"html.txt\"",
 					data:        *bytes.NewBufferString("PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4="),
 				},
 			},
@@ -844,18 +1036,18 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		{
 			"Multiple Inline Attachments - specified MIME types",
 			[]attachment{
-				{"name.txt", strings.NewReader("test"), true, "text/csv; charset=utf-8"},
-				{"different.txt", strings.NewReader("<html><head></head></html>"), true, "application/xml"},
+				{"name.txt", strings.NewReader("test"), true, "text/csv; charset=utf-8", nil},
+				{"different.txt", strings.NewReader("<html><head></head></html>"), true, "application/xml", nil},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/csv; charset=utf-8;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
-					disposition: "inline;\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					contentType: "text/csv; charset=utf-8;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
+					disposition: "inline;\r\n\tfilename=\"name.txt\"; name=\"name.txt\"",
 					data:        *bytes.NewBufferString("dGVzdA=="),
 				},
 				{
-					contentType: "application/xml;\n\tfilename=\"different.txt\"; name=\"different.txt\"",
-					disposition: "inline;\n\tfilename=\"different.txt\"; name=\"different.txt\"",
+					contentType: "application/xml;\r\n\tfilename=\"different.txt\"; name=\"different.txt\"",
+					disposition: "inline;\r\n\tfilename=\"different.txt\"; name=\"different.txt\"",
 					data:        *bytes.NewBufferString("PGh0bWw+PGhlYWQ+PC9oZWFkPjwvaHRtbD4="),
 				},
 			},
@@ -877,6 +1069,7 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 					true,
 					"",
+					nil,
 				},
 				{
 					"520.txt", strings.NewReader(
@@ -889,12 +1082,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 					true,
 					"",
+					nil,
 				},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"550.txt\"; name=\"550.txt\"",
-					disposition: "inline;\n\tfilename=\"550.txt\"; name=\"550.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"550.txt\"; name=\"550.txt\"",
+					disposition: "inline;\r\n\tfilename=\"550.txt\"; name=\"550.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gTWF1cmlzIHV0IG5pc" +
 							"2wgZmVsaXMuIEFlbmVhbiBmZWxpcyBqdXN0bywgZ3JhdmlkYSBlZ2V0IGxlbyBhbGlxdWV0LCBtb2xlc3RpZSBhbGlxdW" +
@@ -907,8 +1101,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"520.txt\"; name=\"520.txt\"",
-					disposition: "inline;\n\tfilename=\"520.txt\"; name=\"520.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"520.txt\"; name=\"520.txt\"",
+					disposition: "inline;\r\n\tfilename=\"520.txt\"; name=\"520.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gRG9uZWMgZXUgdmVz" +
 							"dGlidWx1bSBkb2xvci4gTnVuYyBhYyBwb3N1ZXJlIGZlbGlzLCBhIG1hdHRpcyBsZW8uIER1aXMgZWxlbWVudHVtIHRl" +
@@ -938,6 +1132,7 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 					true,
 					"",
+					nil,
 				},
 				{
 					"550.txt",
@@ -952,12 +1147,13 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 					true,
 					"",
+					nil,
 				},
 			},
 			[]testAttachment{
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"520.txt\"; name=\"520.txt\"",
-					disposition: "inline;\n\tfilename=\"520.txt\"; name=\"520.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"520.txt\"; name=\"520.txt\"",
+					disposition: "inline;\r\n\tfilename=\"520.txt\"; name=\"520.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gRG9uZWMgZXUgdmVz" +
 							"dGlidWx1bSBkb2xvci4gTnVuYyBhYyBwb3N1ZXJlIGZlbGlzLCBhIG1hdHRpcyBsZW8uIER1aXMgZWxlbWVudHVtIHRl" +
@@ -970,8 +1166,8 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 					),
 				},
 				{
-					contentType: "text/plain; charset=utf-8;\n\tfilename=\"550.txt\"; name=\"550.txt\"",
-					disposition: "inline;\n\tfilename=\"550.txt\"; name=\"550.txt\"",
+					contentType: "text/plain; charset=utf-8;\r\n\tfilename=\"550.txt\"; name=\"550.txt\"",
+					disposition: "inline;\r\n\tfilename=\"550.txt\"; name=\"550.txt\"",
 					data: *bytes.NewBufferString(
 						"TG9yZW0gaXBzdW0gZG9sb3Igc2l0IGFtZXQsIGNvbnNlY3RldHVyIGFkaXBpc2NpbmcgZWxpdC4gTWF1cmlzIHV0IG5p" +
 							"c2wgZmVsaXMuIEFlbmVhbiBmZWxpcyBqdXN0bywgZ3JhdmlkYSBlZ2V0IGxlbyBhbGlxdWV0LCBtb2xlc3RpZSBhbGlx" +
@@ -1023,3 +1219,77 @@ func TestMailYakWriteAttachments_multipleAttachments(t *testing.T) {
 		})
 	}
 }
+
+// TestFilenameParams_roundTrip ensures encoded filenames are decoded to the
+// original filename by a MIME parser.
+func TestFilenameParams_roundTrip(t *testing.T) {
+	t.Parallel()
+
+	tests := []string{
+		"simple.txt",
+		"Rechnung_März.pdf",
+		`my "quoted" \ file.txt`,
+		"日本語のファイル名.txt",
+		"Ein sehr langer Dateiname für die Übersicht der Jahresabrechnung des Jahres 2024 (endgültig).pdf",
+		strings.Repeat("a-very-long-ascii-filename-", 4) + "without-any-special-characters-at-all.pdf",
+	}
+
+	for _, filename := range tests {
+		filename := filename
+		t.Run(filename, func(t *testing.T) {
+			t.Parallel()
+
+			header := "Content-Disposition: " + foldParams("attachment", filenameParams(filename))
+			for _, line := range strings.Split(header, "\r\n") {
+				if len(line) > 78 {
+					t.Errorf("line too long (%d characters): %q", len(line), line)
+				}
+			}
+
+			// Unfold the parameters, as a MIME parser would.
+			value := strings.TrimPrefix(strings.Replace(header, "\r\n\t", " ", -1), "Content-Disposition: ")
+			_, got, err := mime.ParseMediaType(value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["filename"] != filename {
+				t.Errorf("filename = %q, want %q", got["filename"], filename)
+			}
+
+			name, err := (&mime.WordDecoder{}).DecodeHeader(got["name"])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != filename {
+				t.Errorf("name = %q, want %q", name, filename)
+			}
+		})
+	}
+}
+
+// TestContentID ensures printable ASCII names are used as the Content-ID, and
+// other names are replaced with a token.
+func TestContentID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		filename string
+		want     string
+	}{
+		{"logo.png", "logo.png"},
+		{"image@example.org", "image@example.org"},
+		{"my file.png", "my file.png"},
+		{"Rechnung_März.pdf", "0afee04fecd72085009d9dd671798a5d"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.filename, func(t *testing.T) {
+			t.Parallel()
+
+			if got := ContentID(tt.filename); got != tt.want {
+				t.Errorf("ContentID(%q) = %q, want %q", tt.filename, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package mailyak
+
+import (
+	"errors"
+	"fmt"
+	"net/smtp"
+	"strings"
+)
+
+// loginAuth implements the LOGIN authentication mechanism.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+// LoginAuth returns an smtp.Auth that implements the LOGIN authentication
+// mechanism, as used by servers (such as many Microsoft Exchange relays) that
+// do not support PLAIN.
+//
+// As with smtp.PlainAuth, the returned Auth uses the given username and
+// password to authenticate to host, and only sends the credentials if the
+// connection is using TLS or is connected to localhost.
+func LoginAuth(username, password, host string) smtp.Auth {
+	return &loginAuth{
+		username: username,
+		password: password,
+		host:     host,
+	}
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if err := checkCredentialsServer(server, a.host); err != nil {
+		return "", nil, err
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	// The mechanism is not formally specified - servers prompt for the
+	// username and password with "Username:" and "Password:", though some use
+	// variations such as "User Name".
+	prompt := strings.ToLower(string(fromServer))
+	switch {
+	case strings.Contains(prompt, "pass"):
+		return []byte(a.password), nil
+	case strings.Contains(prompt, "user"):
+		return []byte(a.username), nil
+	}
+
+	return nil, fmt.Errorf("mailyak: unexpected login prompt %q", fromServer)
+}
+
+// checkCredentialsServer returns an error if server is not host, or if
+// credentials sent to it in the clear would be sent over an unencrypted
+// connection.
+func checkCredentialsServer(server *smtp.ServerInfo, host string) error {
+	// Must have TLS, or else localhost server - mirroring smtp.PlainAuth.
+	if !server.TLS && !isLocalhost(server.Name) {
+		return errors.New("unencrypted connection")
+	}
+	if server.Name != host {
+		return errors.New("wrong host name")
+	}
+	return nil
+}
+
+// isLocalhost returns true if name refers to the local machine.
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
+
+// exchangeAuth is implemented by an smtp.Auth holding the state of a single
+// authentication exchange, such as scramAuth.
+//
+// The handshake of each connection authenticates using the smtp.Auth returned
+// by newExchange, so the same smtp.Auth can be used by several connections at
+// once (such as by a Pool) without exchanges interfering with each other.
+type exchangeAuth interface {
+	newExchange() smtp.Auth
+}
+
+// credentialsMechanism is a SASL mechanism using a username and password.
+type credentialsMechanism struct {
+	name string
+
+	// cleartext is true if the mechanism sends the password in the clear,
+	// requiring a TLS connection.
+	cleartext bool
+
+	newAuth func(a *credentialsAuth) smtp.Auth
+}
+
+// credentialsMechanisms are the mechanisms supported by CredentialsAuth, in
+// order of preference.
+var credentialsMechanisms = []credentialsMechanism{
+	{
+		name: "SCRAM-SHA-256",
+		newAuth: func(a *credentialsAuth) smtp.Auth {
+			return ScramSHA256Auth(a.username, a.password)
+		},
+	},
+	{
+		name: "SCRAM-SHA-1",
+		newAuth: func(a *credentialsAuth) smtp.Auth {
+			return ScramSHA1Auth(a.username, a.password)
+		},
+	},
+	{
+		name: "CRAM-MD5",
+		newAuth: func(a *credentialsAuth) smtp.Auth {
+			return smtp.CRAMMD5Auth(a.username, a.password)
+		},
+	},
+	{
+		name:      "PLAIN",
+		cleartext: true,
+		newAuth: func(a *credentialsAuth) smtp.Auth {
+			return smtp.PlainAuth(a.identity, a.username, a.password, a.host)
+		},
+	},
+	{
+		name:      "LOGIN",
+		cleartext: true,
+		newAuth: func(a *credentialsAuth) smtp.Auth {
+			return LoginAuth(a.username, a.password, a.host)
+		},
+	},
+}
+
+// credentialsAuth negotiates the authentication mechanism used to
+// authenticate with a username and password.
+//
+// Like scramAuth, a credentialsAuth holds the state of a single exchange and
+// is copied by newExchange for each connection.
+type credentialsAuth struct {
+	identity string
+	username string
+	password string
+	host     string
+
+	// selected is the smtp.Auth of the mechanism chosen by Start, used for
+	// the rest of the exchange.
+	selected smtp.Auth
+}
+
+// CredentialsAuth returns an smtp.Auth that authenticates to host with the
+// given username and password, using the strongest mechanism advertised by
+// the server in its EHLO response. The mechanisms are preferred in the order:
+//
+//	SCRAM-SHA-256, SCRAM-SHA-1, CRAM-MD5, PLAIN, LOGIN
+//
+// This allows the same credentials to be used with servers supporting
+// different mechanisms:
+//
+//	mail := mailyak.New("mail.host.com:587", mailyak.CredentialsAuth("", "user", "pass", "mail.host.com"))
+//
+// identity is the optional authorization identity used by PLAIN, as described
+// in smtp.PlainAuth.
+//
+// Mechanisms sending the password in the clear (PLAIN and LOGIN) are only used
+// if the connection is using TLS or is connected to localhost.
+func CredentialsAuth(identity, username, password, host string) smtp.Auth {
+	return &credentialsAuth{
+		identity: identity,
+		username: username,
+		password: password,
+		host:     host,
+	}
+}
+
+// newExchange returns a new credentialsAuth with the same credentials, holding
+// the state of a single exchange.
+func (a *credentialsAuth) newExchange() smtp.Auth {
+	return &credentialsAuth{
+		identity: a.identity,
+		username: a.username,
+		password: a.password,
+		host:     a.host,
+	}
+}
+
+func (a *credentialsAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+
+	secure := server.TLS || isLocalhost(server.Name)
+
+	for _, mech := range credentialsMechanisms {
+		if mech.cleartext && !secure {
+			continue
+		}
+		if !hasMechanism(server.Auth, mech.name) {
+			continue
+		}
+
+		a.selected = mech.newAuth(a)
+		return a.selected.Start(server)
+	}
+
+	if !secure {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "", nil, fmt.Errorf("mailyak: no supported auth mechanism in %q", server.Auth)
+}
+
+func (a *credentialsAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if a.selected == nil {
+		return nil, errors.New("mailyak: unexpected server challenge")
+	}
+	return a.selected.Next(fromServer, more)
+}
+
+// hasMechanism returns true if name is in the list of mechanisms advertised by
+// the server.
+func hasMechanism(advertised []string, name string) bool {
+	for _, m := range advertised {
+		if strings.EqualFold(m, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package mailyak
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoginAuth ensures LOGIN prompts are answered with the credentials, and
+// credentials are not sent over unencrypted connections.
+func TestLoginAuth(t *testing.T) {
+	t.Parallel()
+
+	auth := LoginAuth("user", "pass", "mail.host.com")
+
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "mail.host.com"}); err == nil {
+		t.Error("expected error for unencrypted connection")
+	}
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "other.host.com", TLS: true}); err == nil {
+		t.Error("expected error for wrong host name")
+	}
+
+	mech, resp, err := auth.Start(&smtp.ServerInfo{Name: "mail.host.com", TLS: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mech != "LOGIN" || resp != nil {
+		t.Errorf("Start() = %q, %q, want LOGIN with no initial response", mech, resp)
+	}
+
+	tests := []struct {
+		prompt  string
+		want    string
+		wantErr bool
+	}{
+		{prompt: "Username:", want: "user"},
+		{prompt: "Password:", want: "pass"},
+		{prompt: "User Name\x00", want: "user"},
+		{prompt: "Enter password for user", want: "pass"},
+		{prompt: "Bananas:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := auth.Next([]byte(tt.prompt), true)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Next(%q) error = %v, wantErr %v", tt.prompt, err, tt.wantErr)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("Next(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+
+	if got, err := auth.Next([]byte("Authenticated"), false); got != nil || err != nil {
+		t.Errorf("Next() = %q, %v, want nil", got, err)
+	}
+}
+
+// TestCredentialsAuth ensures the strongest mechanism supported by the server
+// is selected.
+func TestCredentialsAuth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		server   smtp.ServerInfo
+		wantMech string
+		wantResp string
+		wantErr  bool
+	}{
+		{
+			name:     "all mechanisms",
+			server:   smtp.ServerInfo{Name: "mail.host.com", TLS: true, Auth: []string{"LOGIN", "PLAIN", "CRAM-MD5", "SCRAM-SHA-1", "SCRAM-SHA-256"}},
+			wantMech: "SCRAM-SHA-256",
+		},
+		{
+			name:     "scram-sha-1 preferred over cram-md5",
+			server:   smtp.ServerInfo{Name: "mail.host.com", TLS: true, Auth: []string{"LOGIN", "PLAIN", "CRAM-MD5", "SCRAM-SHA-1"}},
+			wantMech: "SCRAM-SHA-1",
+		},
+		{
+			name:     "cram-md5 preferred over plain",
+			server:   smtp.ServerInfo{Name: "mail.host.com", TLS: true, Auth: []string{"LOGIN", "PLAIN", "CRAM-MD5"}},
+			wantMech: "CRAM-MD5",
+		},
+		{
+			name:     "plain preferred over login",
+			server:   smtp.ServerInfo{Name: "mail.host.com", TLS: true, Auth: []string{"LOGIN", "PLAIN"}},
+			wantMech: "PLAIN",
+			wantResp: "ident\x00user\x00pass",
+		},
+		{
+			name:     "login only",
+			server:   smtp.ServerInfo{Name: "mail.host.com", TLS: true, Auth: []string{"login"}},
+			wantMech: "LOGIN",
+		},
+		{
+			name:     "cram-md5 without tls",
+			server:   smtp.ServerInfo{Name: "mail.host.com", Auth: []string{"PLAIN", "CRAM-MD5"}},
+			wantMech: "CRAM-MD5",
+		},
+		{
+			name:    "cleartext without tls",
+			server:  smtp.ServerInfo{Name: "mail.host.com", Auth: []string{"PLAIN", "LOGIN"}},
+			wantErr: true,
+		},
+		{
+			name:    "unsupported mechanisms",
+			server:  smtp.ServerInfo{Name: "mail.host.com", TLS: true, Auth: []string{"GSSAPI", "NTLM"}},
+			wantErr: true,
+		},
+		{
+			name:    "wrong host",
+			server:  smtp.ServerInfo{Name: "other.host.com", TLS: true, Auth: []string{"PLAIN"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			auth := CredentialsAuth("ident", "user", "pass", "mail.host.com")
+
+			mech, resp, err := auth.Start(&tt.server)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got mechanism %q", mech)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if mech != tt.wantMech {
+				t.Errorf("mechanism = %q, want %q", mech, tt.wantMech)
+			}
+			if strings.HasPrefix(mech, "SCRAM-") {
+				// The initial response contains a random nonce.
+				if !strings.HasPrefix(string(resp), "n,,n=user,r=") {
+					t.Errorf("initial response = %q", resp)
+				}
+				return
+			}
+			if string(resp) != tt.wantResp {
+				t.Errorf("initial response = %q, want %q", resp, tt.wantResp)
+			}
+		})
+	}
+}
+
+// TestCredentialsAuth_exchange ensures a negotiated mechanism completes the
+// SMTP AUTH exchange.
+func TestCredentialsAuth_exchange(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+
+		conn, err := socket.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		c := newConnAsserts(conn, t)
+		c.Respond("220 localhost ESMTP bananas\r\n")
+
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250-localhost Hola\r\n")
+		c.Respond("250 AUTH LOGIN\r\n")
+
+		c.Expect("AUTH LOGIN\r\n")
+		c.Respond("334 VXNlcm5hbWU6\r\n")
+		c.Expect("dXNlcg==\r\n")
+		c.Respond("334 UGFzc3dvcmQ6\r\n")
+		c.Expect("cGFzcw==\r\n")
+		c.Respond("235 Authentication successful\r\n")
+
+		c.Expect("MAIL FROM:<from@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("RCPT TO:<to@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("DATA\r\n")
+		c.Respond("354 OK\r\n")
+		c.Expect("bananas\r\n.\r\n")
+		c.Respond("250 Will do friend\r\n")
+		c.Expect("QUIT\r\n")
+		c.Respond("221 Adios\r\n")
+	}()
+
+	err = newSenderWithStartTLS(socket.Addr().String()).Send(context.Background(), &mockMail{
+		toAddrs:  []string{"to@example.org"},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+		auth:     CredentialsAuth("", "user", "pass", "127.0.0.1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-handlerDone:
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for SMTP conversation to complete")
+	}
+}
@@ -0,0 +1,76 @@
+package mailyak
+
+import (
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// RecipientError describes a recipient address rejected by the SMTP server in
+// response to the RCPT TO command.
+type RecipientError struct {
+	// Address is the rejected recipient address.
+	Address string
+
+	// Code is the SMTP reply code (i.e. 550).
+	Code int
+
+	// EnhancedCode is the RFC 3463 enhanced status code (i.e. "5.1.1"), or an
+	// empty string if the server did not provide one.
+	EnhancedCode string
+
+	// Message is the human-readable reply text sent by the server.
+	Message string
+}
+
+// newRecipientError returns a RecipientError for addr from the server reply in
+// err.
+func newRecipientError(addr string, err *textproto.Error) *RecipientError {
+	code, msg := splitEnhancedCode(err.Msg)
+	return &RecipientError{
+		Address:      addr,
+		Code:         err.Code,
+		EnhancedCode: code,
+		Message:      msg,
+	}
+}
+
+func (e *RecipientError) Error() string {
+	reply := fmt.Sprintf("%d", e.Code)
+	if e.EnhancedCode != "" {
+		reply += " " + e.EnhancedCode
+	}
+	if e.Message != "" {
+		reply += " " + e.Message
+	}
+
+	return fmt.Sprintf("mailyak: recipient %q rejected: %s", e.Address, reply)
+}
+
+// PartialDeliveryError is returned when partial delivery is enabled (see
+// AllowPartialDelivery) and one or more recipients were rejected by the SMTP
+// server.
+//
+// If Accepted is non-empty the email was delivered to the accepted
+// recipients, otherwise the email was not sent.
+type PartialDeliveryError struct {
+	// Accepted is the list of recipient addresses accepted by the server.
+	Accepted []string
+
+	// Rejected describes each recipient address rejected by the server.
+	Rejected []*RecipientError
+}
+
+func (e *PartialDeliveryError) Error() string {
+	addrs := make([]string, 0, len(e.Rejected))
+	for _, r := range e.Rejected {
+		addrs = append(addrs, r.Address)
+	}
+
+	return fmt.Sprintf(
+		"mailyak: %d of %d recipients rejected: %s",
+		len(e.Rejected),
+		len(e.Accepted)+len(e.Rejected),
+		strings.Join(addrs, ", "),
+	)
+}
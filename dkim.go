@@ -0,0 +1,402 @@
+package mailyak
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Canonicalization is a DKIM canonicalization algorithm, as defined in RFC 6376
+// section 3.4.
+type Canonicalization string
+
+// The DKIM canonicalization algorithms.
+const (
+	// CanonicalizationSimple tolerates almost no modification of the message
+	// in transit.
+	CanonicalizationSimple Canonicalization = "simple"
+
+	// CanonicalizationRelaxed tolerates common modifications such as
+	// whitespace replacement and header line re-folding.
+	CanonicalizationRelaxed Canonicalization = "relaxed"
+)
+
+// defaultDKIMHeaders is the list of headers signed if DKIMOptions.Headers is
+// not set.
+var defaultDKIMHeaders = []string{
+	"From",
+	"Reply-To",
+	"Subject",
+	"Date",
+	"To",
+	"Cc",
+	"Message-ID",
+	"In-Reply-To",
+	"References",
+	"MIME-Version",
+	"Content-Type",
+}
+
+// DKIMOptions configures the DKIM (RFC 6376) signing of emails.
+type DKIMOptions struct {
+	// Domain is the signing domain (the "d=" tag), typically the domain of
+	// the From address.
+	Domain string
+
+	// Selector is the selector (the "s=" tag) used to locate the public key
+	// in the DNS at <selector>._domainkey.<domain>.
+	Selector string
+
+	// Signer is the private key used to sign emails, and must be either a
+	// *rsa.PrivateKey (rsa-sha256) or an ed25519.PrivateKey
+	// (ed25519-sha256, RFC 8463).
+	Signer crypto.Signer
+
+	// HeaderCanonicalization is the canonicalization algorithm used for the
+	// signed headers.
+	//
+	// Defaults to CanonicalizationRelaxed if empty.
+	HeaderCanonicalization Canonicalization
+
+	// BodyCanonicalization is the canonicalization algorithm used for the
+	// message body.
+	//
+	// Defaults to CanonicalizationRelaxed if empty.
+	BodyCanonicalization Canonicalization
+
+	// Headers is the list of header names to sign. Headers not present in the
+	// email are not signed. The From header is always signed.
+	//
+	// Defaults to a list of the standard headers written by MailYak if empty.
+	Headers []string
+
+	// BodyLength includes the length of the signed body (the "l=" tag) in the
+	// signature.
+	//
+	// This allows content to be appended to the body (such as mailing list
+	// footers) without invalidating the signature, but also allows an
+	// attacker to append content - use with care.
+	BodyLength bool
+}
+
+// dkimSigner signs MIME messages according to a validated DKIMOptions.
+type dkimSigner struct {
+	opts      DKIMOptions
+	algorithm string
+}
+
+// SetDKIM configures m to sign emails using DKIM (RFC 6376), adding a
+// DKIM-Signature header to the output of both Send() and MimeBuf().
+//
+//	err := mail.SetDKIM(&mailyak.DKIMOptions{
+//	    Domain:   "itsallbroken.com",
+//	    Selector: "mail",
+//	    Signer:   privateKey,
+//	})
+//
+// Passing nil disables DKIM signing.
+//
+// When signing is enabled the MIME content is buffered in memory so it can be
+// signed before being sent.
+func (m *MailYak) SetDKIM(opts *DKIMOptions) error {
+	if opts == nil {
+		m.dkim = nil
+		return nil
+	}
+
+	s, err := newDKIMSigner(*opts)
+	if err != nil {
+		return err
+	}
+
+	m.dkim = s
+	return nil
+}
+
+// newDKIMSigner validates opts, initialising any unset fields to their default
+// values.
+func newDKIMSigner(opts DKIMOptions) (*dkimSigner, error) {
+	if opts.Domain == "" {
+		return nil, errors.New("mailyak: dkim domain not set")
+	}
+	if opts.Selector == "" {
+		return nil, errors.New("mailyak: dkim selector not set")
+	}
+
+	var algorithm string
+	switch opts.Signer.(type) {
+	case *rsa.PrivateKey:
+		algorithm = "rsa-sha256"
+	case ed25519.PrivateKey:
+		algorithm = "ed25519-sha256"
+	default:
+		return nil, fmt.Errorf("mailyak: unsupported dkim signer type %T", opts.Signer)
+	}
+
+	for _, c := range []*Canonicalization{&opts.HeaderCanonicalization, &opts.BodyCanonicalization} {
+		switch *c {
+		case "":
+			*c = CanonicalizationRelaxed
+		case CanonicalizationSimple, CanonicalizationRelaxed:
+		default:
+			return nil, fmt.Errorf("mailyak: unknown dkim canonicalization %q", *c)
+		}
+	}
+
+	if len(opts.Headers) == 0 {
+		opts.Headers = defaultDKIMHeaders
+	}
+
+	// The From header must always be signed (RFC 6376 section 5.4).
+	hasFrom := false
+	for _, h := range opts.Headers {
+		if strings.EqualFold(h, "From") {
+			hasFrom = true
+			break
+		}
+	}
+	if !hasFrom {
+		opts.Headers = append([]string{"From"}, opts.Headers...)
+	}
+
+	return &dkimSigner{
+		opts:      opts,
+		algorithm: algorithm,
+	}, nil
+}
+
+// sign writes msg to w, prefixed with a DKIM-Signature header.
+//
+// msg must be a complete MIME message - any bare LF line endings are converted
+// to CRLF before signing, as they would be when sent over SMTP.
+func (s *dkimSigner) sign(w io.Writer, msg []byte) error {
+	msg = canonicalCRLF(msg)
+
+	// Split the header section from the body.
+	var header, body []byte
+	if i := bytes.Index(msg, []byte("\r\n\r\n")); i >= 0 {
+		header, body = msg[:i+2], msg[i+4:]
+	} else {
+		header = msg
+	}
+
+	// Compute the body hash.
+	canonBody := dkimCanonicalBody(s.opts.BodyCanonicalization, body)
+	bodyHash := sha256.Sum256(canonBody)
+
+	// Select the headers to sign, in the order they are listed.
+	names, signed := dkimSelectHeaders(splitHeaderFields(header), s.opts.Headers)
+
+	// Build the DKIM-Signature header with an empty signature.
+	tags := []string{
+		"v=1",
+		"a=" + s.algorithm,
+		fmt.Sprintf("c=%s/%s", s.opts.HeaderCanonicalization, s.opts.BodyCanonicalization),
+		"d=" + s.opts.Domain,
+		"s=" + s.opts.Selector,
+	}
+	if s.opts.BodyLength {
+		tags = append(tags, fmt.Sprintf("l=%d", len(canonBody)))
+	}
+	tags = append(tags,
+		"h="+strings.Join(names, ":"),
+		"bh="+base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	)
+	sigField := "DKIM-Signature: " + strings.Join(tags, ";\r\n\t")
+
+	digest := dkimHeaderHash(s.opts.HeaderCanonicalization, signed, sigField)
+	sig, err := s.signDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	// Write the signature, folded to keep the line length reasonable.
+	b64 := base64.StdEncoding.EncodeToString(sig)
+	if _, err := io.WriteString(w, sigField); err != nil {
+		return err
+	}
+	for len(b64) > 0 {
+		n := 72
+		if n > len(b64) {
+			n = len(b64)
+		}
+		if _, err := io.WriteString(w, "\r\n\t"+b64[:n]); err != nil {
+			return err
+		}
+		b64 = b64[n:]
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	_, err = w.Write(msg)
+	return err
+}
+
+// signDigest signs the header hash digest with the configured key.
+func (s *dkimSigner) signDigest(digest []byte) ([]byte, error) {
+	// RSA keys sign the digest with PKCS #1 v1.5, while Ed25519 keys sign the
+	// digest bytes as the message (RFC 8463 section 3).
+	var opts crypto.SignerOpts = crypto.SHA256
+	if s.algorithm == "ed25519-sha256" {
+		opts = crypto.Hash(0)
+	}
+	return s.opts.Signer.Sign(rand.Reader, digest, opts)
+}
+
+// dkimSelectHeaders returns the header fields to sign for the list of header
+// names, and the lowercase names of the fields selected for the "h=" tag.
+//
+// Each occurrence of a name selects the next instance of the header, starting
+// from the bottom of the header section (RFC 6376 section 5.4.2). Names of
+// headers not present in fields are omitted.
+func dkimSelectHeaders(fields, headers []string) (names, signed []string) {
+	used := make([]bool, len(fields))
+	for _, name := range headers {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if used[i] || !strings.EqualFold(headerFieldName(fields[i]), name) {
+				continue
+			}
+			used[i] = true
+			names = append(names, strings.ToLower(name))
+			signed = append(signed, fields[i])
+			break
+		}
+	}
+	return names, signed
+}
+
+// dkimHeaderHash returns the SHA-256 hash of the signed header fields,
+// followed by the DKIM-Signature header field sigField (with an empty "b="
+// tag, and no trailing CRLF), canonicalized according to c.
+func dkimHeaderHash(c Canonicalization, signed []string, sigField string) []byte {
+	h := sha256.New()
+	for _, f := range signed {
+		_, _ = io.WriteString(h, dkimCanonicalHeader(c, f))
+	}
+
+	// The DKIM-Signature header is hashed without its trailing CRLF.
+	canonSig := dkimCanonicalHeader(c, sigField+"\r\n")
+	_, _ = io.WriteString(h, strings.TrimSuffix(canonSig, "\r\n"))
+
+	return h.Sum(nil)
+}
+
+// canonicalCRLF returns b with all bare LF line endings converted to CRLF.
+func canonicalCRLF(b []byte) []byte {
+	if !bytes.Contains(b, []byte("\n")) {
+		return b
+	}
+
+	out := make([]byte, 0, len(b)+bytes.Count(b, []byte("\n")))
+	for i, c := range b {
+		if c == '\n' && (i == 0 || b[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// splitHeaderFields splits a CRLF-terminated header section into individual
+// header fields, each including any folded continuation lines and the
+// trailing CRLF.
+func splitHeaderFields(header []byte) []string {
+	var fields []string
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			fields[len(fields)-1] += line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	return fields
+}
+
+// headerFieldName returns the name of the header field f.
+func headerFieldName(f string) string {
+	i := strings.IndexByte(f, ':')
+	if i < 0 {
+		return ""
+	}
+	return strings.TrimRight(f[:i], " \t")
+}
+
+// dkimCanonicalHeader canonicalizes the header field f (including its trailing
+// CRLF) according to c.
+func dkimCanonicalHeader(c Canonicalization, f string) string {
+	if c == CanonicalizationSimple {
+		return f
+	}
+
+	// Relaxed header canonicalization (RFC 6376 section 3.4.2).
+	i := strings.IndexByte(f, ':')
+	name := strings.ToLower(strings.TrimRight(f[:i], " \t"))
+
+	value := strings.Replace(f[i+1:], "\r\n", "", -1)
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+
+	return name + ":" + value + "\r\n"
+}
+
+// dkimCanonicalBody canonicalizes the message body b according to c.
+func dkimCanonicalBody(c Canonicalization, b []byte) []byte {
+	if c == CanonicalizationRelaxed {
+		// Relaxed body canonicalization (RFC 6376 section 3.4.4) - reduce
+		// whitespace runs to a single space and remove trailing whitespace
+		// from each line.
+		lines := bytes.SplitAfter(b, []byte("\r\n"))
+		out := make([]byte, 0, len(b))
+		for _, line := range lines {
+			crlf := bytes.HasSuffix(line, []byte("\r\n"))
+			line = bytes.TrimSuffix(line, []byte("\r\n"))
+
+			fields := bytes.FieldsFunc(line, isWSP)
+			if len(fields) > 0 && isWSP(rune(line[0])) {
+				out = append(out, ' ')
+			}
+			out = append(out, bytes.Join(fields, []byte(" "))...)
+			if crlf {
+				out = append(out, "\r\n"...)
+			}
+		}
+		b = out
+	}
+
+	// Remove all trailing empty lines.
+	for bytes.HasSuffix(b, []byte("\r\n\r\n")) {
+		b = b[:len(b)-2]
+	}
+	if bytes.Equal(b, []byte("\r\n")) {
+		b = nil
+	}
+
+	// Ensure a non-empty body ends with a CRLF.
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\r\n")) {
+		b = append(b, "\r\n"...)
+	}
+
+	// The simple canonicalization of an empty body is a single CRLF.
+	if len(b) == 0 && c == CanonicalizationSimple {
+		b = []byte("\r\n")
+	}
+
+	return b
+}
+
+// isWSP returns true if r is a space or horizontal tab.
+func isWSP(r rune) bool {
+	return r == ' ' || r == '\t'
+}
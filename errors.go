@@ -0,0 +1,134 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/textproto"
+	"regexp"
+)
+
+// Stage identifies the step of the SMTP conversation during which an error
+// occurred.
+type Stage string
+
+// The SMTP conversation stages reported by SMTPError.
+const (
+	// StageDial is the connection to the SMTP server, including the TLS
+	// handshake for explicit TLS connections.
+	StageDial Stage = "dial"
+
+	// StageHello is the server greeting and the EHLO/HELO command.
+	StageHello Stage = "hello"
+
+	// StageStartTLS is the STARTTLS command and subsequent TLS handshake.
+	StageStartTLS Stage = "starttls"
+
+	// StageAuth is the AUTH command.
+	StageAuth Stage = "auth"
+
+	// StageMail is the MAIL FROM command.
+	StageMail Stage = "mail"
+
+	// StageRcpt is the RCPT TO command.
+	StageRcpt Stage = "rcpt"
+
+	// StageData is the DATA command and the transfer of the email content.
+	StageData Stage = "data"
+)
+
+// SMTPError describes a failure to send an email over SMTP, and is returned by
+// the SMTP Sender implementations.
+//
+// Use errors.As to inspect the failure:
+//
+//	var smtpErr *mailyak.SMTPError
+//	if errors.As(err, &smtpErr) && smtpErr.Temporary() {
+//	    // Try again later
+//	}
+type SMTPError struct {
+	// Stage is the step of the SMTP conversation that failed.
+	Stage Stage
+
+	// Code is the SMTP reply code (i.e. 550), or 0 if the failure was not
+	// caused by an error reply from the server (such as a network error).
+	Code int
+
+	// EnhancedCode is the RFC 3463 enhanced status code (i.e. "5.1.1"), or an
+	// empty string if the server did not provide one.
+	EnhancedCode string
+
+	// Message is the human-readable reply text sent by the server, if any.
+	Message string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *SMTPError) Error() string {
+	return fmt.Sprintf("mailyak: smtp %s failed: %v", e.Stage, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *SMTPError) Unwrap() error {
+	return e.Err
+}
+
+// Temporary returns true if the server rejected the command with a transient
+// (4xx) reply code, indicating the send may succeed if retried later.
+func (e *SMTPError) Temporary() bool {
+	return e.Code >= 400 && e.Code < 500
+}
+
+// Permanent returns true if the server rejected the command with a permanent
+// (5xx) reply code, indicating the send will not succeed if retried.
+func (e *SMTPError) Permanent() bool {
+	return e.Code >= 500 && e.Code < 600
+}
+
+// newSMTPError wraps err in a SMTPError for stage, extracting the reply codes
+// if err is a server reply. Errors are annotated with the ctx error if ctx is
+// done (see contextError).
+//
+// If err is nil, or already a SMTPError, it is returned unchanged.
+func newSMTPError(ctx context.Context, stage Stage, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var smtpErr *SMTPError
+	if errors.As(err, &smtpErr) {
+		return err
+	}
+
+	out := &SMTPError{
+		Stage: stage,
+		Err:   contextError(ctx, err),
+	}
+
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		out.Code = reply.Code
+		out.EnhancedCode, out.Message = splitEnhancedCode(reply.Msg)
+	}
+
+	return out
+}
+
+// enhancedCodeRegex matches a RFC 3463 enhanced status code at the start of a
+// SMTP reply message.
+var enhancedCodeRegex = regexp.MustCompile(`^([245]\.\d{1,3}\.\d{1,3})(?:\s+|$)`)
+
+// splitEnhancedCode splits the RFC 3463 enhanced status code (i.e. "5.1.1")
+// from the start of msg, returning the code and remaining message text.
+//
+// If msg does not start with an enhanced status code, code is empty and msg is
+// returned unchanged.
+func splitEnhancedCode(msg string) (code, text string) {
+	match := enhancedCodeRegex.FindStringSubmatchIndex(msg)
+	if match == nil {
+		return "", msg
+	}
+
+	return msg[match[2]:match[3]], msg[match[1]:]
+}
@@ -0,0 +1,155 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"testing"
+)
+
+// TestNewSMTPError ensures errors are wrapped with the correct stage and reply
+// codes, and remain matchable with errors.Is and errors.As.
+func TestNewSMTPError(t *testing.T) {
+	t.Parallel()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		stage Stage
+		err   error
+
+		wantCode      int
+		wantEnhanced  string
+		wantMessage   string
+		wantTemporary bool
+		wantPermanent bool
+		wantIs        error
+	}{
+		{
+			name:          "permanent with enhanced code",
+			ctx:           context.Background(),
+			stage:         StageRcpt,
+			err:           &textproto.Error{Code: 550, Msg: "5.1.1 No such user"},
+			wantCode:      550,
+			wantEnhanced:  "5.1.1",
+			wantMessage:   "No such user",
+			wantPermanent: true,
+		},
+		{
+			name:          "temporary without enhanced code",
+			ctx:           context.Background(),
+			stage:         StageMail,
+			err:           &textproto.Error{Code: 451, Msg: "Try again later"},
+			wantCode:      451,
+			wantMessage:   "Try again later",
+			wantTemporary: true,
+		},
+		{
+			name:          "enhanced code only",
+			ctx:           context.Background(),
+			stage:         StageData,
+			err:           &textproto.Error{Code: 421, Msg: "4.7.0"},
+			wantCode:      421,
+			wantEnhanced:  "4.7.0",
+			wantTemporary: true,
+		},
+		{
+			name:   "network error",
+			ctx:    context.Background(),
+			stage:  StageHello,
+			err:    io.EOF,
+			wantIs: io.EOF,
+		},
+		{
+			name:   "context cancelled",
+			ctx:    cancelled,
+			stage:  StageAuth,
+			err:    io.EOF,
+			wantIs: context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := newSMTPError(tt.ctx, tt.stage, tt.err)
+
+			var got *SMTPError
+			if !errors.As(err, &got) {
+				t.Fatalf("got %T, want *SMTPError", err)
+			}
+
+			if got.Stage != tt.stage {
+				t.Errorf("got stage %q, want %q", got.Stage, tt.stage)
+			}
+			if got.Code != tt.wantCode {
+				t.Errorf("got code %d, want %d", got.Code, tt.wantCode)
+			}
+			if got.EnhancedCode != tt.wantEnhanced {
+				t.Errorf("got enhanced code %q, want %q", got.EnhancedCode, tt.wantEnhanced)
+			}
+			if got.Message != tt.wantMessage {
+				t.Errorf("got message %q, want %q", got.Message, tt.wantMessage)
+			}
+			if got.Temporary() != tt.wantTemporary {
+				t.Errorf("got temporary %v, want %v", got.Temporary(), tt.wantTemporary)
+			}
+			if got.Permanent() != tt.wantPermanent {
+				t.Errorf("got permanent %v, want %v", got.Permanent(), tt.wantPermanent)
+			}
+			if tt.wantIs != nil && !errors.Is(err, tt.wantIs) {
+				t.Errorf("errors.Is(%v, %v) = false", err, tt.wantIs)
+			}
+		})
+	}
+}
+
+// TestNewSMTPError_passthrough ensures nil errors and existing SMTPError
+// values are returned unchanged.
+func TestNewSMTPError_passthrough(t *testing.T) {
+	t.Parallel()
+
+	if err := newSMTPError(context.Background(), StageDial, nil); err != nil {
+		t.Errorf("got %v, want nil", err)
+	}
+
+	existing := &SMTPError{Stage: StageDial, Err: io.EOF}
+	if err := newSMTPError(context.Background(), StageData, existing); err != existing {
+		t.Errorf("got %v, want %v", err, existing)
+	}
+}
+
+// TestContextError ensures errors caused by a done context match both the
+// context error and the underlying cause.
+func TestContextError(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cause := &net.OpError{Op: "read", Net: "tcp", Err: io.EOF}
+	err := newSMTPError(ctx, StageData, cause)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("errors.Is(%v, context.Canceled) = false", err)
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Errorf("errors.Is(%v, io.EOF) = false", err)
+	}
+
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) || opErr != cause {
+		t.Errorf("errors.As(%v) = %v, want %v", err, opErr, cause)
+	}
+
+	if err := contextError(context.Background(), cause); err != cause {
+		t.Errorf("got %v, want %v", err, cause)
+	}
+}
@@ -4,6 +4,7 @@ package mailyak
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"io"
 	"net/smtp"
@@ -174,3 +175,28 @@ func ExampleMailYak_AttachInline() {
 		panic(" :( ")
 	}
 }
+
+// fileDropSender is an example Sender implementation that writes each email
+// to an io.Writer instead of sending it over SMTP.
+type fileDropSender struct {
+	w io.Writer
+}
+
+func (s *fileDropSender) Send(ctx context.Context, m SendableMail) error {
+	return m.WriteMime(s.w)
+}
+
+func ExampleMailYak_UseSender() {
+	// Create a new email without an SMTP server.
+	mail := New("", nil)
+	mail.To("dom@itsallbroken.com")
+	mail.From("jsmith@example.com")
+	mail.Plain().Set("Delivered by a custom transport")
+
+	// Swap the SMTP transport for the custom Sender implementation.
+	mail.UseSender(&fileDropSender{w: &bytes.Buffer{}})
+
+	if err := mail.Send(); err != nil {
+		panic(" :( ")
+	}
+}
@@ -2,8 +2,10 @@ package mailyak
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net/mail"
 	"net/smtp"
 	"regexp"
@@ -16,22 +18,36 @@ type MailYak struct {
 	html  BodyPart
 	plain BodyPart
 
-	localName      string
-	toAddrs        []string
-	ccAddrs        []string
-	bccAddrs       []string
-	subject        string
-	fromAddr       string
-	fromName       string
-	replyTo        string
-	headers        map[string][]string // arbitrary headers
-	attachments    []attachment
-	trimRegex      *regexp.Regexp
-	auth           smtp.Auth
-	host           string
-	sender         emailSender
-	writeBccHeader bool
-	date           string
+	localName       string
+	toAddrs         []string
+	ccAddrs         []string
+	bccAddrs        []string
+	subject         string
+	fromAddr        string
+	fromName        string
+	replyTo         string
+	messageID       string
+	messageIDSet    bool // messageID was set by SetMessageID
+	messageIDSent   bool // messageID has been used by a send
+	messageIDDomain string
+	inReplyTo       []string
+	references      []string
+	headers         map[string][]string // arbitrary headers
+	headerOrder     []string            // custom header names in insertion order
+	attachments     []attachment
+	opened          []io.ReadCloser // attachment content opened by prepare
+	trimRegex       *regexp.Regexp
+	auth            smtp.Auth
+	host            string
+	sender          Sender
+	writeBccHeader  bool
+	allowPartial    bool
+	dkim            *dkimSigner
+	smime           *smimeWrapper
+	pgp             *pgpWrapper
+	date            string
+	clock           func() time.Time
+	boundaryGen     func() (string, error)
 }
 
 // Email Date timestamp format
@@ -50,8 +66,9 @@ const mailDateFormat = time.RFC1123Z
 //	))
 //
 // MailYak instances created with New will switch to using TLS after connecting
-// if the remote host supports the STARTTLS command. For an explicit TLS
-// connection, or to provide a custom tls.Config, use NewWithTLS() instead.
+// if the remote host supports the STARTTLS command - use SetTLSPolicy() to
+// require TLS, and SetStartTLSConfig() to provide a custom tls.Config. For an
+// explicit TLS connection, use NewWithTLS() instead.
 func New(host string, auth smtp.Auth) *MailYak {
 	return &MailYak{
 		headers:        map[string][]string{},
@@ -95,29 +112,130 @@ func NewWithTLS(host string, auth smtp.Auth, tlsConfig *tls.Config) (*MailYak, e
 
 // Send attempts to send the built email via the configured SMTP server.
 //
-// Attachments are read and the email timestamp is created when Send() is
-// called, and any connection/authentication errors will be returned by Send().
+// Attachments are opened and the email timestamp is created when Send() is
+// called, before connecting to the SMTP server, so an attachment that cannot be
+// opened fails the send without delivering a partial email. Any
+// connection/authentication errors will be returned by Send().
+//
+// Send never times out - use SendContext to bound the time spent sending.
 func (m *MailYak) Send() error {
-	m.date = time.Now().Format(mailDateFormat)
+	return m.SendContext(context.Background())
+}
+
+// SendContext attempts to send the built email via the configured SMTP server,
+// aborting if ctx is cancelled or its deadline expires.
+//
+// ctx bounds the entire SMTP conversation, including the connection dial, the
+// EHLO/STARTTLS/AUTH handshake, each RCPT command and the DATA stream. If the
+// send is aborted because ctx is done, the returned error wraps ctx.Err() and
+// can be matched with errors.Is:
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+//	defer cancel()
+//
+//	if err := mail.SendContext(ctx); errors.Is(err, context.DeadlineExceeded) {
+//	    // The SMTP server took too long to respond
+//	}
+//
+// Attachments are read and the email timestamp is created when SendContext()
+// is called.
+func (m *MailYak) SendContext(ctx context.Context) error {
+	if err := m.prepare(); err != nil {
+		return err
+	}
+	defer m.closeAttachments()
 
-	return m.sender.Send(m)
+	return m.sender.Send(ctx, m)
 }
 
 // MimeBuf returns the buffer containing all the RAW MIME data.
 //
 // MimeBuf is typically used with an API service such as Amazon SES that does
 // not use an SMTP interface.
+//
+// MimeBuf holds the entire email in memory - use WriteTo() or Reader() to
+// stream the MIME content instead.
 func (m *MailYak) MimeBuf() (*bytes.Buffer, error) {
-	m.date = time.Now().Format(mailDateFormat)
-
 	buf := &bytes.Buffer{}
-	if err := m.buildMime(buf); err != nil {
+	if _, err := m.WriteTo(buf); err != nil {
 		return nil, err
 	}
 
 	return buf, nil
 }
 
+// WriteTo writes the RAW MIME data of the email to w, implementing
+// io.WriterTo.
+//
+// Unlike MimeBuf(), the email is streamed to w as it is generated, with the
+// attachments read as they are written, so large emails can be passed to an
+// API service without holding the whole email in memory:
+//
+//	if _, err := mail.WriteTo(file); err != nil {
+//	    return err
+//	}
+//
+// As with MimeBuf(), the email timestamp is updated when WriteTo is called.
+// Emails signed with DKIM, S/MIME or PGP/MIME are buffered in memory in order
+// to be signed.
+func (m *MailYak) WriteTo(w io.Writer) (int64, error) {
+	if err := m.prepare(); err != nil {
+		return 0, err
+	}
+	defer m.closeAttachments()
+
+	cw := &countWriter{w: w}
+	err := m.buildMime(cw)
+	return cw.n, err
+}
+
+// Reader returns an io.ReadCloser streaming the RAW MIME data of the email,
+// such as for use as an HTTP request body:
+//
+//	r := mail.Reader()
+//	defer r.Close()
+//
+//	resp, err := http.Post(apiURL, "message/rfc822", r)
+//
+// The email is generated in a separate goroutine as it is read, and any error
+// generating the email is returned by Read. Closing the reader before reading
+// the whole email stops the generation. m must not be modified until Read has
+// returned io.EOF or an error.
+//
+// As with MimeBuf(), the email timestamp is updated when Reader is called.
+func (m *MailYak) Reader() io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	if err := m.prepare(); err != nil {
+		_ = pw.CloseWithError(err)
+		return pr
+	}
+
+	go func() {
+		err := m.buildMime(pw)
+
+		// Release the attachments before the reader observes the end of the
+		// email, after which m may be modified.
+		m.closeAttachments()
+		_ = pw.CloseWithError(err)
+	}()
+
+	return pr
+}
+
+// prepare sets the email timestamp, opens the attachments and generates the
+// Message-ID if required, before the MIME content is built.
+//
+// The opened attachments must be released with closeAttachments once the email
+// has been built.
+func (m *MailYak) prepare() error {
+	m.date = m.now().Format(mailDateFormat)
+	if err := m.openAttachments(); err != nil {
+		return err
+	}
+	return m.nextMessageID()
+}
+
 // String returns a redacted description of the email state, typically for
 // logging or debugging purposes.
 //
@@ -133,13 +251,17 @@ func (m *MailYak) String() string {
 
 	if len(m.headers) > 0 {
 		var hdrs []string
-		for k, v := range m.headers {
-			hdrs = append(hdrs, fmt.Sprintf("%s: %q", k, v))
+		for _, k := range m.headerOrder {
+			hdrs = append(hdrs, fmt.Sprintf("%s: %q", k, m.headers[k]))
 		}
 		custom = strings.Join(hdrs, ", ") + ", "
 	}
 
-	_, isTLSSender := m.sender.(*senderExplicitTLS)
+	sender := m.sender
+	if rs, ok := sender.(*retrySender); ok {
+		sender = rs.relays[0].sender
+	}
+	_, isTLSSender := sender.(*senderExplicitTLS)
 
 	return fmt.Sprintf(
 		"&MailYak{date: %q, from: %q, fromName: %q, html: %v bytes, plain: %v bytes, toAddrs: %v, "+
@@ -161,6 +283,14 @@ func (m *MailYak) String() string {
 	)
 }
 
+// now returns the current time according to the clock set by SetClock().
+func (m *MailYak) now() time.Time {
+	if m.clock == nil {
+		return time.Now()
+	}
+	return m.clock()
+}
+
 // HTML returns a BodyPart for the HTML email body.
 func (m *MailYak) HTML() *BodyPart {
 	return &m.html
@@ -171,14 +301,45 @@ func (m *MailYak) Plain() *BodyPart {
 	return &m.plain
 }
 
-// getLocalName should return the sender domain to be used in the EHLO/HELO
-// command.
-func (m *MailYak) getLocalName() string {
-	return m.localName
+// UseSender configures m to deliver emails using s, replacing the SMTP
+// transport configured by New() or NewWithTLS().
+//
+// This allows the MIME content built by MailYak to be delivered by any
+// transport, such as an HTTP email API or a file drop:
+//
+//	mail := mailyak.New("", nil)
+//	mail.UseSender(myAPISender)
+//
+// When using a custom Sender, the host and auth passed to the constructor are
+// only used if s chooses to use them.
+func (m *MailYak) UseSender(s Sender) {
+	m.sender = s
 }
 
-// getToAddrs should return a slice of email addresses to be added to the
-// RCPT TO command.
+// Envelope returns the SMTP envelope information for the email, used by a
+// Sender to deliver it.
+func (m *MailYak) Envelope() Envelope {
+	return Envelope{
+		LocalName: m.localName,
+		From:      m.fromAddr,
+		To:        m.getToAddrs(),
+		Auth:      m.auth,
+
+		AllowPartialDelivery: m.allowPartial,
+	}
+}
+
+// WriteMime writes the generated MIME content of the email to w.
+//
+// WriteMime is typically called by a Sender during Send() - the email timestamp
+// is not updated by WriteMime, use MimeBuf() to generate the MIME content
+// outside of a Send() call.
+func (m *MailYak) WriteMime(w io.Writer) error {
+	return m.buildMime(w)
+}
+
+// getToAddrs returns a slice of email addresses to be added to the RCPT TO
+// command.
 func (m *MailYak) getToAddrs() []string {
 	// Pre-allocate the slice to avoid growing it, we already know how big it
 	// needs to be.
@@ -192,17 +353,6 @@ func (m *MailYak) getToAddrs() []string {
 	return out
 }
 
-// getFromAddr should return the address to be used in the MAIL FROM
-// command.
-func (m *MailYak) getFromAddr() string {
-	return m.fromAddr
-}
-
-// getAuth should return the smtp.Auth if configured, nil if not.
-func (m *MailYak) getAuth() smtp.Auth {
-	return m.auth
-}
-
 // stripNames returns a new slice with only the email parts from the RFC 5322 addresses.
 //
 // Or in other words, converts:
@@ -1,8 +1,14 @@
 package mailyak
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/smtp"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -59,6 +65,148 @@ func TestMailYakDate(t *testing.T) {
 	}
 }
 
+// TestMailYakMimeBuf_deterministic ensures emails built with a fixed clock,
+// boundary generator and Message-ID are byte-for-byte reproducible.
+func TestMailYakMimeBuf_deterministic(t *testing.T) {
+	t.Parallel()
+
+	build := func() []byte {
+		mail := New("mail.host.com:25", nil)
+		mail.From("from@example.org")
+		mail.To("to@example.org")
+		mail.Subject("Test subject")
+		mail.SetMessageID("fixed@example.org")
+		for _, name := range []string{"X-Zebra", "X-Apple", "Precedence", "X-Mango"} {
+			mail.AddHeader(name, "value")
+		}
+		mail.HTML().Set("<p>HTML</p><img src=\"cid:logo.png\">")
+		mail.Plain().Set("Plain")
+		mail.Attach("test.txt", strings.NewReader("attachment"))
+		mail.AttachInline("logo.png", strings.NewReader("image"))
+
+		mail.SetClock(func() time.Time {
+			return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		})
+
+		var n int
+		mail.SetBoundaryGenerator(func() (string, error) {
+			n++
+			return fmt.Sprintf("boundary-%d", n), nil
+		})
+
+		buf, err := mail.MimeBuf()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	first := build()
+	for i := 0; i < 10; i++ {
+		if got := build(); !bytes.Equal(got, first) {
+			t.Fatalf("output differs:\ngot:  %q\nwant: %q", got, first)
+		}
+	}
+
+	for _, want := range []string{
+		"Date: Tue, 02 Jan 2024 03:04:05 +0000\r\n",
+		"Message-ID: <fixed@example.org>\r\n",
+		"X-Zebra: value\r\nX-Apple: value\r\nPrecedence: value\r\nX-Mango: value\r\n",
+		"boundary=\"boundary-1\"",
+		"boundary=\"boundary-2\"",
+		"boundary=\"boundary-3\"",
+	} {
+		if !bytes.Contains(first, []byte(want)) {
+			t.Errorf("output missing %q:\n%s", want, first)
+		}
+	}
+}
+
+// newStreamingTestMail returns a MailYak with deterministic output and an
+// attachment larger than the internal buffers.
+func newStreamingTestMail() *MailYak {
+	m := New("mail.host.com:25", nil)
+	m.From("from@example.org")
+	m.To("to@example.org")
+	m.Subject("Streaming")
+	m.SetMessageID("stream@example.org")
+	m.SetClock(func() time.Time { return time.Unix(0, 0) })
+	m.SetBoundaryGenerator(func() (string, error) { return "boundary", nil })
+	m.Plain().Set("Plain")
+	m.AttachBytes("large.bin", bytes.Repeat([]byte("0123456789"), 100000))
+	return m
+}
+
+// TestMailYakWriteTo ensures WriteTo writes the same content as MimeBuf, and
+// returns the number of bytes written.
+func TestMailYakWriteTo(t *testing.T) {
+	t.Parallel()
+
+	var _ io.WriterTo = (*MailYak)(nil)
+
+	m := newStreamingTestMail()
+	want, err := m.MimeBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &bytes.Buffer{}
+	n, err := m.WriteTo(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Error("WriteTo() output differs from MimeBuf()")
+	}
+	if n != int64(got.Len()) {
+		t.Errorf("WriteTo() = %d, want %d", n, got.Len())
+	}
+}
+
+// TestMailYakReader ensures Reader streams the email content, and returns any
+// error generating it.
+func TestMailYakReader(t *testing.T) {
+	t.Parallel()
+
+	m := newStreamingTestMail()
+	want, err := m.MimeBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := m.Reader()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Error("Reader() output differs from MimeBuf()")
+	}
+
+	// Closing the reader early stops generating the email.
+	r = m.Reader()
+	if _, err := io.ReadFull(r, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	wantErr := errors.New("no such file")
+	m = newStreamingTestMail()
+	m.AttachFunc("missing.txt", func() (io.ReadCloser, error) {
+		return nil, wantErr
+	})
+
+	if _, err := ioutil.ReadAll(m.Reader()); !errors.Is(err, wantErr) {
+		t.Fatalf("got %v, want %v", err, wantErr)
+	}
+}
+
 // TestStripNames ensures that the stripNames() method correctly
 // remove the name part of a list of RFC 5322 addresses.
 func TestStripNames(t *testing.T) {
@@ -92,3 +240,74 @@ func TestStripNames(t *testing.T) {
 		}
 	}
 }
+
+// recordingSender is a Sender that records the envelope and MIME content of
+// the emails it is asked to send.
+type recordingSender struct {
+	envelopes []Envelope
+	mime      []string
+	err       error
+}
+
+func (s *recordingSender) Send(ctx context.Context, m SendableMail) error {
+	buf := &bytes.Buffer{}
+	if err := m.WriteMime(buf); err != nil {
+		return err
+	}
+
+	s.envelopes = append(s.envelopes, m.Envelope())
+	s.mime = append(s.mime, buf.String())
+
+	return s.err
+}
+
+// TestMailYakUseSender ensures a custom Sender is used to deliver the email,
+// and is provided with the correct envelope and MIME content.
+func TestMailYakUseSender(t *testing.T) {
+	t.Parallel()
+
+	auth := smtp.PlainAuth("", "user", "pass", "mail.host.com")
+
+	mail := New("mail.host.com:25", auth)
+	mail.LocalName("example.com")
+	mail.From("from@example.org")
+	mail.To("Dom <to@example.org>")
+	mail.Cc("cc@example.org")
+	mail.Bcc("bcc@example.org")
+	mail.Subject("Test subject")
+	mail.Plain().Set("bananas")
+
+	sender := &recordingSender{}
+	mail.UseSender(sender)
+
+	if err := mail.Send(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.envelopes) != 1 {
+		t.Fatalf("got %d sends, want 1", len(sender.envelopes))
+	}
+
+	want := Envelope{
+		LocalName: "example.com",
+		From:      "from@example.org",
+		To:        []string{"to@example.org", "cc@example.org", "bcc@example.org"},
+		Auth:      auth,
+	}
+	if got := sender.envelopes[0]; !reflect.DeepEqual(got, want) {
+		t.Errorf("got envelope %+v, want %+v", got, want)
+	}
+
+	if !strings.Contains(sender.mime[0], "Subject: Test subject\r\n") {
+		t.Errorf("MIME content missing subject: %q", sender.mime[0])
+	}
+	if strings.Contains(sender.mime[0], "bcc@example.org") {
+		t.Errorf("MIME content contains BCC address: %q", sender.mime[0])
+	}
+
+	// Errors from the sender must be returned to the caller.
+	sender.err = errors.New("bananas")
+	if err := mail.Send(); err != sender.err {
+		t.Errorf("got %v, want %v", err, sender.err)
+	}
+}
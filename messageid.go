@@ -0,0 +1,115 @@
+package mailyak
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// defaultMessageIDDomain is used to generate a Message-ID when neither a
+// Message-ID domain or From address is set.
+const defaultMessageIDDomain = "localhost"
+
+// MessageID returns the Message-ID of the email, including the angle brackets.
+//
+// If a Message-ID has not been set with SetMessageID(), a new unique ID is
+// generated for each call to Send() or MimeBuf(), so re-sending m (such as to
+// different recipients) does not send duplicate Message-IDs. MessageID()
+// returns the ID of the last email sent, or if called before sending, the ID
+// that will be used by the next send:
+//
+//	id := mail.MessageID()
+//	if err := mail.Send(); err != nil {
+//	    return err
+//	}
+//	db.RecordSent(id)
+//
+// The generated ID uses the domain set by MessageIDDomain(), or the domain of
+// the From address if unset - both must be set before the ID is generated.
+//
+// An empty string is returned if an ID cannot be generated.
+func (m *MailYak) MessageID() string {
+	if m.messageID == "" {
+		_ = m.generateMessageID()
+	}
+	return m.messageID
+}
+
+// SetMessageID sets the Message-ID of the email, replacing any generated ID,
+// and used for all subsequent sends.
+//
+// The angle brackets are added if id does not include them. Setting an empty
+// id causes a new ID to be generated for each send.
+func (m *MailYak) SetMessageID(id string) {
+	m.messageID = formatMessageID(m.trimRegex.ReplaceAllString(id, ""))
+	m.messageIDSet = m.messageID != ""
+	m.messageIDSent = false
+}
+
+// MessageIDDomain sets the domain used when generating the Message-ID, which
+// defaults to the domain of the From address.
+//
+// The domain is only used for IDs generated after it is set.
+func (m *MailYak) MessageIDDomain(domain string) {
+	m.messageIDDomain = m.trimRegex.ReplaceAllString(domain, "")
+}
+
+// nextMessageID sets the Message-ID used for the email being sent, generating
+// a new ID unless one was set with SetMessageID(), or has been generated by
+// MessageID() and not yet sent.
+func (m *MailYak) nextMessageID() error {
+	if !m.messageIDSet && (m.messageID == "" || m.messageIDSent) {
+		if err := m.generateMessageID(); err != nil {
+			return err
+		}
+	}
+
+	m.messageIDSent = true
+	return nil
+}
+
+// generateMessageID sets the Message-ID of m to a new unique ID.
+func (m *MailYak) generateMessageID() error {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return err
+	}
+
+	domain := m.messageIDDomain
+	if domain == "" {
+		if i := strings.LastIndexByte(m.fromAddr, '@'); i >= 0 {
+			domain = m.fromAddr[i+1:]
+		}
+	}
+	if domain == "" {
+		domain = defaultMessageIDDomain
+	}
+
+	m.messageID = fmt.Sprintf("<%s@%s>", hex.EncodeToString(buf), domain)
+	m.messageIDSent = false
+	return nil
+}
+
+// formatMessageID returns id wrapped in angle brackets, or an empty string if
+// id is empty.
+func formatMessageID(id string) string {
+	id = strings.TrimSpace(id)
+	if id == "" {
+		return ""
+	}
+
+	return "<" + strings.TrimSuffix(strings.TrimPrefix(id, "<"), ">") + ">"
+}
+
+// formatMessageIDs returns the non-empty ids wrapped in angle brackets.
+func (m *MailYak) formatMessageIDs(ids []string) []string {
+	out := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id := formatMessageID(m.trimRegex.ReplaceAllString(id, "")); id != "" {
+			out = append(out, id)
+		}
+	}
+	return out
+}
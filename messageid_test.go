@@ -0,0 +1,153 @@
+package mailyak
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestMailYakMessageID ensures a Message-ID is generated using the expected
+// domain, and that generated IDs change for each send while explicit IDs do
+// not.
+func TestMailYakMessageID(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		from     string
+		domain   string
+		setID    string
+		wantID   *regexp.Regexp
+		wantSame bool // the same ID is used for subsequent sends
+	}{
+		{
+			name:   "from domain",
+			from:   "dom@itsallbroken.com",
+			wantID: regexp.MustCompile(`^<[0-9a-f]{32}@itsallbroken\.com>$`),
+		},
+		{
+			name:   "configured domain",
+			from:   "dom@itsallbroken.com",
+			domain: "mail.example.org",
+			wantID: regexp.MustCompile(`^<[0-9a-f]{32}@mail\.example\.org>$`),
+		},
+		{
+			name:   "no from",
+			wantID: regexp.MustCompile(`^<[0-9a-f]{32}@localhost>$`),
+		},
+		{
+			name:     "explicit",
+			from:     "dom@itsallbroken.com",
+			setID:    "custom-id@itsallbroken.com",
+			wantID:   regexp.MustCompile(`^<custom-id@itsallbroken\.com>$`),
+			wantSame: true,
+		},
+		{
+			name:     "explicit with brackets",
+			setID:    "<custom-id@itsallbroken.com>\r\n",
+			wantID:   regexp.MustCompile(`^<custom-id@itsallbroken\.com>$`),
+			wantSame: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := New("mail.host.com:25", nil)
+			m.From(tt.from)
+			m.MessageIDDomain(tt.domain)
+			m.SetMessageID(tt.setID)
+
+			id := m.MessageID()
+			if !tt.wantID.MatchString(id) {
+				t.Fatalf("MessageID() = %q, want match for %v", id, tt.wantID)
+			}
+
+			buf, err := m.MimeBuf()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(buf.String(), "\r\nMessage-ID: "+id+"\r\n") {
+				t.Errorf("Message-ID header %q not found in:\n%s", id, buf.String())
+			}
+
+			if m.MessageID() != id {
+				t.Errorf("MessageID() changed after MimeBuf: got %q, want %q", m.MessageID(), id)
+			}
+
+			buf, err = m.MimeBuf()
+			if err != nil {
+				t.Fatal(err)
+			}
+			next := m.MessageID()
+			if !tt.wantID.MatchString(next) {
+				t.Fatalf("MessageID() = %q, want match for %v", next, tt.wantID)
+			}
+			if !strings.Contains(buf.String(), "\r\nMessage-ID: "+next+"\r\n") {
+				t.Errorf("Message-ID header %q not found in:\n%s", next, buf.String())
+			}
+			if (next == id) != tt.wantSame {
+				t.Errorf("second send used Message-ID %q, first used %q", next, id)
+			}
+		})
+	}
+}
+
+// TestMailYakMessageID_unique ensures each email is given a unique Message-ID,
+// and that clearing the Message-ID generates a new one.
+func TestMailYakMessageID_unique(t *testing.T) {
+	t.Parallel()
+
+	a := New("mail.host.com:25", nil)
+	b := New("mail.host.com:25", nil)
+	if a.MessageID() == b.MessageID() {
+		t.Fatalf("got duplicate Message-ID %q", a.MessageID())
+	}
+
+	id := a.MessageID()
+	a.SetMessageID("")
+	if a.MessageID() == id {
+		t.Fatal("Message-ID not regenerated after being cleared")
+	}
+}
+
+// TestMailYakThreadingHeaders ensures the In-Reply-To and References headers
+// are written.
+func TestMailYakThreadingHeaders(t *testing.T) {
+	t.Parallel()
+
+	m := New("mail.host.com:25", nil)
+	m.SetMessageID("reply@itsallbroken.com")
+	m.InReplyTo("<parent@itsallbroken.com>", "")
+	m.References("root@itsallbroken.com", "<parent@itsallbroken.com>\n")
+
+	buf := &bytes.Buffer{}
+	if err := m.writeHeaders(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"\r\nMessage-ID: <reply@itsallbroken.com>\r\n",
+		"\r\nIn-Reply-To: <parent@itsallbroken.com>\r\n",
+		"\r\nReferences: <root@itsallbroken.com> <parent@itsallbroken.com>\r\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("header %q not found in:\n%s", want, buf.String())
+		}
+	}
+
+	// Clearing the threading headers removes them.
+	m.InReplyTo()
+	m.References()
+
+	buf.Reset()
+	if err := m.writeHeaders(buf); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "In-Reply-To") || strings.Contains(buf.String(), "References") {
+		t.Errorf("threading headers not cleared:\n%s", buf.String())
+	}
+}
@@ -6,24 +6,50 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"mime"
 	"mime/multipart"
 	"mime/quotedprintable"
+	"net/mail"
 	"net/textproto"
 	"strings"
 )
 
 func (m *MailYak) buildMime(w io.Writer) error {
-	mb, err := randomBoundary()
+	mb, err := m.newBoundary()
 	if err != nil {
 		return err
 	}
 
-	ab, err := randomBoundary()
+	ab, err := m.newBoundary()
 	if err != nil {
 		return err
 	}
 
-	return m.buildMimeWithBoundaries(w, mb, ab)
+	rb, err := m.newBoundary()
+	if err != nil {
+		return err
+	}
+
+	if m.dkim == nil {
+		return m.buildMimeWithBoundaries(w, mb, ab, rb)
+	}
+
+	// The complete message must be available to generate the DKIM signature.
+	buf := &bytes.Buffer{}
+	if err := m.buildMimeWithBoundaries(buf, mb, ab, rb); err != nil {
+		return err
+	}
+
+	return m.dkim.sign(w, buf.Bytes())
+}
+
+// newBoundary returns a MIME boundary from the generator set by
+// SetBoundaryGenerator(), or a random boundary if unset.
+func (m *MailYak) newBoundary() (string, error) {
+	if m.boundaryGen == nil {
+		return randomBoundary()
+	}
+	return m.boundaryGen()
 }
 
 // randomBoundary returns a random hexadecimal string used for separating MIME
@@ -40,18 +66,82 @@ func randomBoundary() (string, error) {
 	return hex.EncodeToString(buf), nil
 }
 
-// buildMimeWithBoundaries creates the MIME message using mb and ab as MIME
-// boundaries, and returns the generated MIME data as a buffer.
-func (m *MailYak) buildMimeWithBoundaries(w io.Writer, mb, ab string) error {
+// buildMimeWithBoundaries creates the MIME message using mb, ab and rb as the
+// multipart/mixed, multipart/alternative and multipart/related MIME boundaries,
+// and writes the generated MIME data to w.
+func (m *MailYak) buildMimeWithBoundaries(w io.Writer, mb, ab, rb string) error {
 	if err := m.writeHeaders(w); err != nil {
 		return err
 	}
 
+	wrapper := m.entityWrapper()
+	if wrapper == nil {
+		return m.writeBodyEntity(w, mb, ab, rb)
+	}
+
+	// Generate the body entity so it can be signed and/or encrypted.
+	buf := &bytes.Buffer{}
+	if err := m.writeBodyEntity(buf, mb, ab, rb); err != nil {
+		return err
+	}
+
+	return wrapper.wrap(w, buf.Bytes())
+}
+
+// entityWrapper wraps the MIME entity containing the email body and
+// attachments, such as to sign or encrypt it.
+type entityWrapper interface {
+	wrap(w io.Writer, entity []byte) error
+}
+
+// entityWrapper returns the configured S/MIME or PGP/MIME entityWrapper, or nil
+// if neither is configured.
+func (m *MailYak) entityWrapper() entityWrapper {
+	switch {
+	case m.smime != nil:
+		return m.smime
+	case m.pgp != nil:
+		return m.pgp
+	}
+	return nil
+}
+
+// writeBodyEntity writes the MIME entity containing the email body and
+// attachments - the Content-Type header, followed by the multipart/mixed
+// content using mb, ab and rb as MIME boundaries.
+//
+// When the email has both a body and inline attachments, the body and inline
+// attachments are grouped in a multipart/related part, giving the structure:
+//
+//	multipart/mixed
+//	├── multipart/related
+//	│   ├── multipart/alternative
+//	│   │   ├── text/plain
+//	│   │   └── text/html
+//	│   └── inline attachments
+//	└── attachments
+func (m *MailYak) writeBodyEntity(w io.Writer, mb, ab, rb string) error {
 	var (
 		hasBody        = m.html.Len() != 0 || m.plain.Len() != 0
 		hasAttachments = len(m.attachments) != 0
 	)
 
+	// Separate the inline attachments referenced by the body from the
+	// attachments, unless there is no body for them to be displayed within.
+	var inline, attached []attachment
+	for i, a := range m.attachments {
+		// Use the content opened by prepare, if any.
+		if rc := m.takeOpened(i); rc != nil {
+			a.open = func() (io.ReadCloser, error) { return rc, nil }
+		}
+
+		if a.inline && hasBody {
+			inline = append(inline, a)
+			continue
+		}
+		attached = append(attached, a)
+	}
+
 	// if we don't have text/html body or attachments we can skip Content-Type header
 	// in that case next default will be assumed
 	//    Content-type: text/plain; charset=us-ascii
@@ -72,15 +162,26 @@ func (m *MailYak) buildMimeWithBoundaries(w io.Writer, mb, ab string) error {
 	// To avoid deferring a mixed.Close(), run the write in a closure and
 	// close the mixed after.
 	tryWrite := func() error {
-		fmt.Fprintf(w, "Content-Type: multipart/mixed;\r\n\tboundary=\"%s\"; charset=UTF-8\r\n\r\n", mixed.Boundary())
+		ctype := foldParams("multipart/mixed", []string{
+			fmt.Sprintf(`boundary="%s"`, mixed.Boundary()),
+			"charset=UTF-8",
+		})
+		if _, err := fmt.Fprintf(w, "Content-Type: %s\r\n\r\n", ctype); err != nil {
+			return err
+		}
 
-		if hasBody {
+		switch {
+		case len(inline) > 0:
+			if err := m.writeRelatedPart(mixed, rb, ab, inline); err != nil {
+				return err
+			}
+		case hasBody:
 			if err := m.writeAlternativePart(mixed, ab); err != nil {
 				return err
 			}
 		}
-		if hasAttachments {
-			if err := m.writeAttachments(mixed, lineSplitterBuilder{}); err != nil {
+		if len(attached) > 0 {
+			if err := m.writeAttachmentParts(mixed, lineSplitterBuilder{}, attached); err != nil {
 				return err
 			}
 		}
@@ -94,57 +195,304 @@ func (m *MailYak) buildMimeWithBoundaries(w io.Writer, mb, ab string) error {
 	return mixed.Close()
 }
 
-// writeHeaders writes the MIME-Version, Date, Reply-To, From, To and Subject headers,
-// plus any custom headers set via AddHeader().
+// writeHeaders writes the email headers in a consistent, conventional order:
+//
+//	Return-Path, Date, From, Sender, Reply-To, To, CC, BCC, Subject,
+//	Message-ID, In-Reply-To, References, MIME-Version
+//
+// followed by any other custom headers set via AddHeader() or SetHeader() in
+// the order they were first added. Return-Path and Sender are only written if
+// set as custom headers.
 func (m *MailYak) writeHeaders(w io.Writer) error {
-	if _, err := w.Write([]byte(m.fromHeader())); err != nil {
+	err := m.writeCustomHeaders(w, func(name string) bool {
+		return strings.EqualFold(name, "Return-Path")
+	})
+	if err != nil {
 		return err
 	}
 
-	if _, err := w.Write([]byte("MIME-Version: 1.0\r\n")); err != nil {
+	if _, err := fmt.Fprintf(w, "Date: %s\r\n", m.date); err != nil {
 		return err
 	}
 
-	fmt.Fprintf(w, "Date: %s\r\n", m.date)
+	from, err := m.fromHeader()
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, from); err != nil {
+		return err
+	}
 
-	if m.replyTo != "" {
-		fmt.Fprintf(w, "Reply-To: %s\r\n", m.replyTo)
+	err = m.writeCustomHeaders(w, func(name string) bool {
+		return strings.EqualFold(name, "Sender")
+	})
+	if err != nil {
+		return err
 	}
 
-	fmt.Fprintf(w, "Subject: %s\r\n", m.subject)
+	addrHeaders := []struct {
+		name  string
+		addrs []string
+		write bool
+	}{
+		{"Reply-To", []string{m.replyTo}, m.replyTo != ""},
+		{"To", m.toAddrs, len(m.toAddrs) > 0},
+		{"CC", m.ccAddrs, len(m.ccAddrs) > 0},
+		{"BCC", m.bccAddrs, m.writeBccHeader && len(m.bccAddrs) > 0},
+	}
+	for _, h := range addrHeaders {
+		if !h.write {
+			continue
+		}
+		if err := writeAddressHeader(w, h.name, h.addrs); err != nil {
+			return err
+		}
+	}
 
-	if len(m.toAddrs) > 0 {
-		commaSeparatedToAddrs := strings.Join(m.toAddrs, ",")
-		fmt.Fprintf(w, "To: %s\r\n", commaSeparatedToAddrs)
+	if err := writeHeader(w, "Subject", m.subject); err != nil {
+		return err
 	}
 
-	if len(m.ccAddrs) > 0 {
-		commaSeparatedCCAddrs := strings.Join(m.ccAddrs, ",")
-		fmt.Fprintf(w, "CC: %s\r\n", commaSeparatedCCAddrs)
+	optional := []struct {
+		name  string
+		value string
+	}{
+		{"Message-ID", m.messageID},
+		{"In-Reply-To", strings.Join(m.inReplyTo, " ")},
+		{"References", strings.Join(m.references, " ")},
+	}
+	for _, h := range optional {
+		if h.value == "" {
+			continue
+		}
+		if err := writeHeader(w, h.name, h.value); err != nil {
+			return err
+		}
 	}
 
-	if m.writeBccHeader && len(m.bccAddrs) > 0 {
-		commaSeparatedBCCAddrs := strings.Join(m.bccAddrs, ",")
-		fmt.Fprintf(w, "BCC: %s\r\n", commaSeparatedBCCAddrs)
+	if _, err := io.WriteString(w, "MIME-Version: 1.0\r\n"); err != nil {
+		return err
 	}
 
-	for k, values := range m.headers {
-		for _, v := range values {
-			fmt.Fprintf(w, "%s: %s\r\n", k, v)
+	return m.writeCustomHeaders(w, func(name string) bool {
+		return !strings.EqualFold(name, "Return-Path") && !strings.EqualFold(name, "Sender")
+	})
+}
+
+// writeCustomHeaders writes the custom headers with a name matching match, in
+// the order they were added.
+func (m *MailYak) writeCustomHeaders(w io.Writer, match func(name string) bool) error {
+	for _, k := range m.headerOrder {
+		if !match(k) {
+			continue
+		}
+		for _, v := range m.headers[k] {
+			if err := writeHeader(w, k, v); err != nil {
+				return err
+			}
 		}
 	}
-
 	return nil
 }
 
+// maxHeaderLineLen is the line length (excluding the CRLF) headers are folded
+// to fit within where possible, as recommended by RFC 5322 section 2.1.1.
+const maxHeaderLineLen = 78
+
+// maxLineLenLimit is the maximum length of a line (excluding the CRLF)
+// permitted by RFC 5322 section 2.1.1.
+const maxLineLenLimit = 998
+
+// writeHeader writes the header name and value to w, folded by foldHeader.
+func writeHeader(w io.Writer, name, value string) error {
+	header, err := foldHeader(name, value)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, header)
+	return err
+}
+
+// foldHeader returns the header name and value terminated by a CRLF, folding
+// the value at whitespace to keep lines within maxHeaderLineLen.
+//
+// RFC 2047 encoded-words are separated by whitespace and are never split, and
+// a word longer than the line length is written on a line of its own. An
+// error is returned if a word cannot fit within the maxLineLenLimit.
+func foldHeader(name, value string) (string, error) {
+	var b strings.Builder
+
+	line, empty := name+":", true
+	for _, word := range strings.Split(value, " ") {
+		// Fold before this word if it would make the line too long, unless
+		// the line holds no words to fold after.
+		if word != "" && !empty && len(line)+len(" ")+len(word) > maxHeaderLineLen {
+			b.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += " " + word
+		empty = empty && word == ""
+
+		if len(line) > maxLineLenLimit {
+			return "", fmt.Errorf("mailyak: %s header contains a word longer than the %d character line limit", name, maxLineLenLimit)
+		}
+	}
+	b.WriteString(line + "\r\n")
+
+	return b.String(), nil
+}
+
+// foldParams returns the MIME header value with the params appended, each
+// separated by a semicolon.
+//
+// The params start on a new line, and are folded between parameters to keep
+// lines within maxHeaderLineLen where possible. A param may contain folds of
+// its own.
+func foldParams(value string, params []string) string {
+	b := strings.Builder{}
+	b.WriteString(value)
+
+	line := ""
+	for i, p := range params {
+		first := p
+		if j := strings.Index(p, "\r\n"); j >= 0 {
+			first = p[:j]
+		}
+
+		// Fold before this param if it would make the line (including a
+		// trailing semicolon) too long.
+		if i == 0 || len(line)+len("; ")+len(first)+len(";") > maxHeaderLineLen {
+			b.WriteString(";\r\n\t")
+			line = "\t"
+		} else {
+			b.WriteString("; ")
+			line += "; "
+		}
+		b.WriteString(p)
+
+		if j := strings.LastIndex(p, "\r\n"); j >= 0 {
+			line = p[j+len("\r\n"):]
+		} else {
+			line += p
+		}
+	}
+
+	return b.String()
+}
+
+// writeAddressHeader writes the header name with the comma separated addrs,
+// folding the header between addresses to keep lines within maxHeaderLineLen.
+//
+// Each address is re-serialised with any non-ASCII display name encoded
+// according to RFC 2047, and special characters quoted. Addresses that cannot
+// be parsed are written as provided.
+func writeAddressHeader(w io.Writer, name string, addrs []string) error {
+	var formatted []string
+	for _, addr := range addrs {
+		formatted = append(formatted, formatAddressList(addr)...)
+	}
+
+	line := name + ": "
+	var b strings.Builder
+	for i, addr := range formatted {
+		if i > 0 {
+			// Fold before this address if it would make the line too long.
+			if len(line)+len(",")+len(addr) > maxHeaderLineLen {
+				b.WriteString(line + ",\r\n")
+				line = " "
+			} else {
+				line += ","
+			}
+		}
+		line += addr
+	}
+	b.WriteString(line + "\r\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// formatAddressList parses the RFC 5322 address list addr, returning each
+// address with an encoded display name.
+//
+// If addr cannot be parsed, it is returned unchanged.
+func formatAddressList(addr string) []string {
+	list, err := mail.ParseAddressList(addr)
+	if err != nil {
+		return []string{addr}
+	}
+
+	return addressStrings(list)
+}
+
+// addressStrings returns the RFC 5322 string form of each address in addrs,
+// omitting the angle brackets for addresses without a name.
+func addressStrings(addrs []*mail.Address) []string {
+	out := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if a.Name == "" {
+			out = append(out, a.Address)
+			continue
+		}
+		out = append(out, a.String())
+	}
+	return out
+}
+
 // fromHeader returns a correctly formatted From header, optionally with a name
 // component.
-func (m *MailYak) fromHeader() string {
+//
+// The name is encoded and quoted in the same way as the display names of the
+// other address headers, so names containing special characters such as
+// commas are not split into multiple addresses. If the address cannot be
+// parsed, the header is written as provided.
+func (m *MailYak) fromHeader() (string, error) {
 	if m.fromName == "" {
-		return fmt.Sprintf("From: %s\r\n", m.fromAddr)
+		return foldHeader("From", m.fromAddr)
+	}
+
+	if _, err := mail.ParseAddress(m.fromAddr); err != nil {
+		return foldHeader("From", fmt.Sprintf("%s <%s>", m.fromName, m.fromAddr))
+	}
+
+	// FromName stores the name as RFC 2047 encoded-words if required.
+	name, err := (&mime.WordDecoder{}).DecodeHeader(m.fromName)
+	if err != nil {
+		name = m.fromName
+	}
+
+	from := addressStrings([]*mail.Address{{Name: name, Address: m.fromAddr}})
+	return foldHeader("From", from[0])
+}
+
+// writeRelatedPart writes a multipart/related part containing the body and the
+// inline attachments it references, using boundary as the related boundary and
+// altBoundary as the alternative boundary.
+func (m *MailYak) writeRelatedPart(mixed *multipart.Writer, boundary, altBoundary string, inline []attachment) error {
+	ctype := foldParams("multipart/related", []string{
+		fmt.Sprintf(`boundary="%s"`, boundary),
+		`type="multipart/alternative"`,
+	})
+
+	relatedPart, err := mixed.CreatePart(textproto.MIMEHeader{"Content-Type": {ctype}})
+	if err != nil {
+		return err
+	}
+
+	related := multipart.NewWriter(relatedPart)
+	if err := related.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	if err := m.writeAlternativePart(related, altBoundary); err != nil {
+		return err
+	}
+
+	if err := m.writeAttachmentParts(related, lineSplitterBuilder{}, inline); err != nil {
+		return err
 	}
 
-	return fmt.Sprintf("From: %s <%s>\r\n", m.fromName, m.fromAddr)
+	return related.Close()
 }
 
 func (m *MailYak) writeAlternativePart(mixed *multipart.Writer, boundary string) error {
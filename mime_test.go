@@ -2,6 +2,7 @@ package mailyak
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -31,7 +32,19 @@ func TestMailYakFromHeader(t *testing.T) {
 			"With name",
 			"dom@itsallbroken.com",
 			"Dom",
-			"From: Dom <dom@itsallbroken.com>\r\n",
+			"From: \"Dom\" <dom@itsallbroken.com>\r\n",
+		},
+		{
+			"Name with comma",
+			"john@itsallbroken.com",
+			"Doe, John",
+			"From: \"Doe, John\" <john@itsallbroken.com>\r\n",
+		},
+		{
+			"Encoded name",
+			"zoe@itsallbroken.com",
+			"=?UTF-8?q?Zo=C3=AB?=",
+			"From: =?utf-8?q?Zo=C3=AB?= <zoe@itsallbroken.com>\r\n",
 		},
 		{
 			"Without name",
@@ -56,7 +69,11 @@ func TestMailYakFromHeader(t *testing.T) {
 				fromName: tt.rfromName,
 			}
 
-			if got := m.fromHeader(); got != tt.want {
+			got, err := m.fromHeader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
 				t.Errorf("%q. MailYak.fromHeader() = %v, want %v", tt.name, got, tt.want)
 			}
 		})
@@ -90,7 +107,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"Test",
 			"help@itsallbroken.com",
 			true,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nReply-To: help@itsallbroken.com\r\nSubject: Test\r\nTo: test@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nReply-To: help@itsallbroken.com\r\nTo: test@itsallbroken.com\r\nSubject: Test\r\nMIME-Version: 1.0\r\n",
 		},
 		{
 			"No reply-to",
@@ -100,7 +117,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"",
 			"",
 			true,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: test@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
 		},
 		{
 			"Multiple To addresses",
@@ -110,7 +127,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"",
 			"",
 			true,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
 		},
 		{
 			"Single Cc address, Multiple To addresses",
@@ -120,7 +137,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"",
 			"",
 			true,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nCC: cc@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nCC: cc@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
 		},
 		{
 			"Multiple Cc addresses, Multiple To addresses",
@@ -130,7 +147,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"",
 			"",
 			true,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nCC: cc1@itsallbroken.com,cc2@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nCC: cc1@itsallbroken.com,cc2@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
 		},
 		{
 			"Single Bcc address, Multiple To addresses",
@@ -140,7 +157,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"",
 			"",
 			true,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nBCC: bcc@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nBCC: bcc@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
 		},
 		{
 			"Multiple Bcc addresses, Multiple To addresses",
@@ -150,7 +167,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"",
 			"",
 			true,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nBCC: bcc1@itsallbroken.com,bcc2@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nBCC: bcc1@itsallbroken.com,bcc2@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
 		},
 		{
 			"Multiple Bcc addresses, Multiple To addresses",
@@ -160,7 +177,7 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"",
 			"",
 			false,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
 		},
 		{
 			"All together now",
@@ -170,7 +187,47 @@ func TestMailYakWriteHeaders(t *testing.T) {
 			"",
 			"",
 			true,
-			"From: Dom <dom@itsallbroken.com>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nCC: cc1@itsallbroken.com,cc2@itsallbroken.com\r\nBCC: bcc1@itsallbroken.com,bcc2@itsallbroken.com\r\n",
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com,repairs@itsallbroken.com\r\nCC: cc1@itsallbroken.com,cc2@itsallbroken.com\r\nBCC: bcc1@itsallbroken.com,bcc2@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
+		},
+		{
+			"Encoded display names",
+			[]string{"José Müller <jose@itsallbroken.com>", "\"Dwyer, Dom\" <dom@itsallbroken.com>"},
+			[]string{"Zoë <zoe@itsallbroken.com>, plain@itsallbroken.com"},
+			[]string{},
+			"",
+			"Hélp Desk <help@itsallbroken.com>",
+			true,
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nReply-To: =?utf-8?q?H=C3=A9lp_Desk?= <help@itsallbroken.com>\r\nTo: =?utf-8?q?Jos=C3=A9_M=C3=BCller?= <jose@itsallbroken.com>,\r\n \"Dwyer, Dom\" <dom@itsallbroken.com>\r\nCC: =?utf-8?q?Zo=C3=AB?= <zoe@itsallbroken.com>,plain@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
+		},
+		{
+			"Folded address list",
+			[]string{"first.address@itsallbroken.com", "second.address@itsallbroken.com", "third.address@itsallbroken.com", "fourth.address@itsallbroken.com"},
+			[]string{},
+			[]string{},
+			"",
+			"",
+			true,
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: first.address@itsallbroken.com,second.address@itsallbroken.com,\r\n third.address@itsallbroken.com,fourth.address@itsallbroken.com\r\nSubject: \r\nMIME-Version: 1.0\r\n",
+		},
+		{
+			"Folded subject",
+			[]string{"test@itsallbroken.com"},
+			[]string{},
+			[]string{},
+			"This subject is much longer than the recommended line length and is folded",
+			"",
+			true,
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: test@itsallbroken.com\r\nSubject: This subject is much longer than the recommended line length and is\r\n folded\r\nMIME-Version: 1.0\r\n",
+		},
+		{
+			"Invalid address written as-is",
+			[]string{"not an address"},
+			[]string{},
+			[]string{},
+			"",
+			"",
+			true,
+			"Date: " + now + "\r\nFrom: \"Dom\" <dom@itsallbroken.com>\r\nTo: not an address\r\nSubject: \r\nMIME-Version: 1.0\r\n",
 		},
 	}
 	for _, tt := range tests {
@@ -202,6 +259,257 @@ func TestMailYakWriteHeaders(t *testing.T) {
 	}
 }
 
+// TestMailYakWriteHeaders_order ensures the standard headers are written in
+// the conventional order, followed by the custom headers in insertion order.
+func TestMailYakWriteHeaders_order(t *testing.T) {
+	t.Parallel()
+
+	m := New("mail.host.com:25", nil)
+	m.date = "now"
+	m.From("from@itsallbroken.com")
+	m.To("to@itsallbroken.com")
+	m.Cc("cc@itsallbroken.com")
+	m.ReplyTo("reply@itsallbroken.com")
+	m.Subject("Subject")
+	m.SetMessageID("id@itsallbroken.com")
+	m.InReplyTo("parent@itsallbroken.com")
+	m.References("parent@itsallbroken.com")
+	m.AddHeader("X-Second", "2")
+	m.AddHeader("Sender", "sender@itsallbroken.com")
+	m.AddHeader("X-First", "1")
+	m.AddHeader("Return-Path", "<bounce@itsallbroken.com>")
+	m.AddHeader("X-Second", "3")
+	m.SetHeader("X-First", "4")
+
+	want := "Return-Path: <bounce@itsallbroken.com>\r\n" +
+		"Date: now\r\n" +
+		"From: from@itsallbroken.com\r\n" +
+		"Sender: sender@itsallbroken.com\r\n" +
+		"Reply-To: reply@itsallbroken.com\r\n" +
+		"To: to@itsallbroken.com\r\n" +
+		"CC: cc@itsallbroken.com\r\n" +
+		"Subject: Subject\r\n" +
+		"Message-ID: <id@itsallbroken.com>\r\n" +
+		"In-Reply-To: <parent@itsallbroken.com>\r\n" +
+		"References: <parent@itsallbroken.com>\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"X-Second: 2\r\n" +
+		"X-Second: 3\r\n" +
+		"X-First: 4\r\n"
+
+	for i := 0; i < 10; i++ {
+		buf := &bytes.Buffer{}
+		if err := m.writeHeaders(buf); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != want {
+			t.Fatalf("MailYak.writeHeaders() = %q, want %q", got, want)
+		}
+	}
+}
+
+// TestFoldHeader ensures long header values are folded at whitespace without
+// splitting encoded-words, and unfold to the original value.
+func TestFoldHeader(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{"Empty", ""},
+		{"Short", "Test"},
+		{"Long", strings.Repeat("bananas ", 20) + "end"},
+		{"Encoded", mime.QEncoding.Encode("UTF-8", strings.Repeat("Überprüfung der Rechnung ", 8))},
+		{"Long word", strings.Repeat("x", 100) + " short " + strings.Repeat("y", 100)},
+		{"References", strings.TrimSpace(strings.Repeat("<0123456789abcdef@itsallbroken.com> ", 6))},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := foldHeader("Subject", tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(got, "\r\n") {
+				t.Fatalf("header %q is not terminated by CRLF", got)
+			}
+
+			lines := strings.Split(strings.TrimSuffix(got, "\r\n"), "\r\n")
+			for i, line := range lines {
+				// A single word longer than the limit cannot be folded.
+				words := strings.Fields(line)
+				if i == 0 {
+					words = words[1:]
+				}
+				if len(line) > 78 && len(words) > 1 {
+					t.Errorf("line too long (%d characters): %q", len(line), line)
+				}
+				if i > 0 && !strings.HasPrefix(line, " ") {
+					t.Errorf("continuation line %q does not start with whitespace", line)
+				}
+			}
+
+			// Unfold the header, as a parser would.
+			unfolded := strings.Replace(strings.TrimSuffix(got, "\r\n"), "\r\n", "", -1)
+			if want := "Subject: " + tt.value; unfolded != want {
+				t.Errorf("unfolded = %q, want %q", unfolded, want)
+			}
+		})
+	}
+}
+
+// TestFoldHeader_longWord ensures a word too long to fit within the line length
+// limit is rejected.
+func TestFoldHeader_longWord(t *testing.T) {
+	t.Parallel()
+
+	// The longest word that fits on a continuation line of its own.
+	word := strings.Repeat("x", 997)
+	if _, err := foldHeader("Subject", "A "+word); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err := foldHeader("Subject", "A "+word+"x"); err == nil {
+		t.Fatal("expected error for a word longer than the line limit")
+	}
+
+	m := New("mail.host.com:25", nil)
+	m.From("dom@itsallbroken.com")
+	m.To("test@itsallbroken.com")
+	m.AddHeader("X-Token", strings.Repeat("t", 1000))
+	if _, err := m.MimeBuf(); err == nil {
+		t.Fatal("expected error building an email with an overlong header")
+	}
+}
+
+// TestFoldParams ensures MIME parameters start on a new line and are folded
+// between parameters when a line would be too long.
+func TestFoldParams(t *testing.T) {
+	t.Parallel()
+
+	boundary := strings.Repeat("a", 60)
+
+	tests := []struct {
+		name   string
+		value  string
+		params []string
+		want   string
+	}{
+		{
+			"Short",
+			"multipart/mixed",
+			[]string{`boundary="mixed"`, "charset=UTF-8"},
+			"multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8",
+		},
+		{
+			"Long",
+			"multipart/mixed",
+			[]string{`boundary="` + boundary + `"`, "charset=UTF-8"},
+			"multipart/mixed;\r\n\tboundary=\"" + boundary + "\";\r\n\tcharset=UTF-8",
+		},
+		{
+			"Folded param",
+			"attachment",
+			[]string{"filename*=UTF-8''a%C3%A4", "name=\"=?UTF-8?q?a?=\r\n\t=?UTF-8?q?=C3=A4?=\"", "size=1"},
+			"attachment;\r\n\tfilename*=UTF-8''a%C3%A4; name=\"=?UTF-8?q?a?=\r\n\t=?UTF-8?q?=C3=A4?=\"; size=1",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := foldParams(tt.value, tt.params); got != tt.want {
+				t.Errorf("foldParams() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestMailYakBuildMime_lineLength ensures the generated headers are folded to
+// fit within 78 characters.
+func TestMailYakBuildMime_lineLength(t *testing.T) {
+	t.Parallel()
+
+	m := New("mail.host.com:25", nil)
+	m.From("dom@itsallbroken.com")
+	m.FromName("Dom")
+	m.To("test@itsallbroken.com")
+	m.Subject("A subject long enough to need folding onto a second line of the header")
+	m.HTML().Set(`<img src="cid:logo.png">`)
+	m.Plain().Set("Plain")
+	m.AttachInline("logo.png", strings.NewReader("logo"))
+	m.Attach("Quarterly report for the finance department - final revision.pdf", strings.NewReader("report"))
+	m.Attach("Ein sehr langer Dateiname für die Übersicht der Jahresabrechnung 2024.txt", strings.NewReader("Bericht"))
+
+	buf, err := m.MimeBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		// A Content-ID cannot be split, and is written on a line of its own
+		// if too long.
+		if strings.HasPrefix(line, "\t<") {
+			continue
+		}
+		if len(line) > 78 {
+			t.Errorf("line too long (%d characters): %q", len(line), line)
+		}
+	}
+}
+
+// failingWriter returns an error for all writes after the first n.
+type failingWriter struct {
+	n int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, errors.New("write failed")
+	}
+	w.n--
+	return len(p), nil
+}
+
+// TestMailYakWriteHeaders_writeError ensures a failed write of any header is
+// returned.
+func TestMailYakWriteHeaders_writeError(t *testing.T) {
+	t.Parallel()
+
+	m := New("mail.host.com:25", nil)
+	m.From("dom@itsallbroken.com")
+	m.ReplyTo("help@itsallbroken.com")
+	m.To("test@itsallbroken.com")
+	m.Cc("cc@itsallbroken.com")
+	m.Bcc("bcc@itsallbroken.com")
+	m.WriteBccHeader(true)
+	m.SetMessageID("id@itsallbroken.com")
+	m.InReplyTo("parent@itsallbroken.com")
+	m.References("root@itsallbroken.com")
+	m.AddHeader("Return-Path", "<bounce@itsallbroken.com>")
+	m.AddHeader("Sender", "sender@itsallbroken.com")
+	m.AddHeader("X-Custom", "bananas")
+
+	// Count the writes made by writeHeaders.
+	counter := &failingWriter{n: 1000}
+	if err := m.writeHeaders(counter); err != nil {
+		t.Fatal(err)
+	}
+	writes := 1000 - counter.n
+
+	for n := 0; n < writes; n++ {
+		if err := m.writeHeaders(&failingWriter{n: n}); err == nil {
+			t.Errorf("write %d failed without returning an error", n)
+		}
+	}
+}
+
 // TestMailYakWriteBody ensures the correct MIME parts are wrote for the body
 func TestMailYakWriteBody(t *testing.T) {
 	t.Parallel()
@@ -303,7 +611,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"",
-			"From: \r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: \r\n\r\n",
+			"Date: " + now + "\r\nFrom: \r\nTo: \r\nSubject: \r\nMIME-Version: 1.0\r\n\r\n",
 			false,
 		},
 		{
@@ -316,7 +624,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"",
-			"From: \r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: \r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n--alt\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/html; charset=UTF-8\r\n\r\nHTML\r\n--alt--\r\n\r\n--mixed--\r\n",
+			"Date: " + now + "\r\nFrom: \r\nTo: \r\nSubject: \r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n--alt\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/html; charset=UTF-8\r\n\r\nHTML\r\n--alt--\r\n\r\n--mixed--\r\n",
 			false,
 		},
 		{
@@ -329,7 +637,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"",
-			"From: \r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: \r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n--alt\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--alt--\r\n\r\n--mixed--\r\n",
+			"Date: " + now + "\r\nFrom: \r\nTo: \r\nSubject: \r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n--alt\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n--alt--\r\n\r\n--mixed--\r\n",
 			false,
 		},
 		{
@@ -342,7 +650,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"attachment",
-			"From: \r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: \r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Disposition: attachment;\n\tfilename=\"testAttachment\"; name=\"testAttachment\"\r\nContent-ID: <testAttachment>\r\nContent-Transfer-Encoding: base64\r\nContent-Type: text/plain; charset=utf-8;\n\tfilename=\"testAttachment\"; name=\"testAttachment\"\r\n\r\nYXR0YWNobWVudA==\r\n--mixed--\r\n",
+			"Date: " + now + "\r\nFrom: \r\nTo: \r\nSubject: \r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n--mixed\r\nContent-Disposition: attachment;\r\n\tfilename=\"testAttachment\"; name=\"testAttachment\"\r\nContent-ID: <testAttachment>\r\nContent-Transfer-Encoding: base64\r\nContent-Type: text/plain; charset=utf-8;\r\n\tfilename=\"testAttachment\"; name=\"testAttachment\"\r\n\r\nYXR0YWNobWVudA==\r\n--mixed--\r\n",
 			false,
 		},
 		{
@@ -355,7 +663,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"reply",
 			"",
-			"From: \r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nReply-To: reply\r\nSubject: \r\nTo: \r\n\r\n",
+			"Date: " + now + "\r\nFrom: \r\nReply-To: reply\r\nTo: \r\nSubject: \r\nMIME-Version: 1.0\r\n\r\n",
 			false,
 		},
 		{
@@ -368,7 +676,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"name",
 			"",
 			"",
-			"From: name <>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: \r\n\r\n",
+			"Date: " + now + "\r\nFrom: name <>\r\nTo: \r\nSubject: \r\nMIME-Version: 1.0\r\n\r\n",
 			false,
 		},
 		{
@@ -381,7 +689,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"name",
 			"",
 			"",
-			"From: name <addr>\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: \r\n\r\n",
+			"Date: " + now + "\r\nFrom: name <addr>\r\nTo: \r\nSubject: \r\nMIME-Version: 1.0\r\n\r\n",
 			false,
 		},
 		{
@@ -394,7 +702,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"",
-			"From: from\r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: \r\n\r\n",
+			"Date: " + now + "\r\nFrom: from\r\nTo: \r\nSubject: \r\nMIME-Version: 1.0\r\n\r\n",
 			false,
 		},
 		{
@@ -407,7 +715,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"",
-			"From: \r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: subject\r\nTo: \r\n\r\n",
+			"Date: " + now + "\r\nFrom: \r\nTo: \r\nSubject: subject\r\nMIME-Version: 1.0\r\n\r\n",
 			false,
 		},
 		{
@@ -420,7 +728,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			"",
 			"",
 			"",
-			"From: \r\nMIME-Version: 1.0\r\nDate: " + now + "\r\nSubject: \r\nTo: one,two\r\n\r\n",
+			"Date: " + now + "\r\nFrom: \r\nTo: one,two\r\nSubject: \r\nMIME-Version: 1.0\r\n\r\n",
 			false,
 		},
 	}
@@ -448,7 +756,7 @@ func TestMailYakBuildMime(t *testing.T) {
 			}
 
 			buf := &bytes.Buffer{}
-			err := m.buildMimeWithBoundaries(buf, "mixed", "alt")
+			err := m.buildMimeWithBoundaries(buf, "mixed", "alt", "related")
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("%q. MailYak.buildMime() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			}
@@ -503,7 +811,7 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			"",
 			"",
 			[]attachment{
-				{"test.txt", strings.NewReader("content"), false, ""},
+				{"test.txt", strings.NewReader("content"), false, "", nil},
 			},
 			[]string{"Y29udGVudA=="},
 			false,
@@ -518,7 +826,7 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			"",
 			"",
 			[]attachment{
-				{"test.txt", strings.NewReader("content"), true, ""},
+				{"test.txt", strings.NewReader("content"), true, "", nil},
 			},
 			[]string{"Y29udGVudA=="},
 			false,
@@ -533,8 +841,8 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			"",
 			"",
 			[]attachment{
-				{"test.txt", strings.NewReader("content"), false, ""},
-				{"another.txt", strings.NewReader("another"), false, ""},
+				{"test.txt", strings.NewReader("content"), false, "", nil},
+				{"another.txt", strings.NewReader("another"), false, "", nil},
 			},
 			[]string{"Y29udGVudA==", "YW5vdGhlcg=="},
 			false,
@@ -549,8 +857,8 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			"",
 			"",
 			[]attachment{
-				{"test.txt", strings.NewReader("content"), true, ""},
-				{"another.txt", strings.NewReader("another"), true, ""},
+				{"test.txt", strings.NewReader("content"), true, "", nil},
+				{"another.txt", strings.NewReader("another"), true, "", nil},
 			},
 			[]string{"Y29udGVudA==", "YW5vdGhlcg=="},
 			false,
@@ -577,7 +885,7 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 			m.Plain().Write(tt.rPlain)
 
 			buf := &bytes.Buffer{}
-			err := m.buildMimeWithBoundaries(buf, "mixed", "alt")
+			err := m.buildMimeWithBoundaries(buf, "mixed", "alt", "related")
 			if (err != nil) != tt.wantErr {
 				t.Fatalf("%q. MailYak.buildMime() error = %v, wantErr %v", tt.name, err, tt.wantErr)
 			}
@@ -655,3 +963,38 @@ func TestMailYakBuildMime_withAttachments(t *testing.T) {
 		})
 	}
 }
+
+// TestMailYakBuildMime_related ensures the body and inline attachments are
+// wrapped in a multipart/related part, separate from the other attachments.
+func TestMailYakBuildMime_related(t *testing.T) {
+	t.Parallel()
+
+	m := &MailYak{
+		trimRegex: regexp.MustCompile("\r?\n"),
+		date:      "now",
+	}
+	m.HTML().Set(`<img src="cid:logo.png">`)
+	m.Plain().Set("Plain")
+	m.AttachInline("logo.png", strings.NewReader("logo"))
+	m.Attach("doc.txt", strings.NewReader("doc"))
+
+	want := "Date: now\r\nFrom: \r\nSubject: \r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed;\r\n\tboundary=\"mixed\"; charset=UTF-8\r\n\r\n" +
+		"--mixed\r\nContent-Type: multipart/related;\r\n\tboundary=\"related\"; type=\"multipart/alternative\"\r\n\r\n" +
+		"--related\r\nContent-Type: multipart/alternative;\r\n\tboundary=\"alt\"\r\n\r\n" +
+		"--alt\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nPlain\r\n" +
+		"--alt\r\nContent-Transfer-Encoding: quoted-printable\r\nContent-Type: text/html; charset=UTF-8\r\n\r\n<img src=3D\"cid:logo.png\">\r\n" +
+		"--alt--\r\n\r\n" +
+		"--related\r\nContent-Disposition: inline;\r\n\tfilename=\"logo.png\"; name=\"logo.png\"\r\nContent-ID: <logo.png>\r\nContent-Transfer-Encoding: base64\r\nContent-Type: text/plain; charset=utf-8;\r\n\tfilename=\"logo.png\"; name=\"logo.png\"\r\n\r\nbG9nbw==\r\n" +
+		"--related--\r\n\r\n" +
+		"--mixed\r\nContent-Disposition: attachment;\r\n\tfilename=\"doc.txt\"; name=\"doc.txt\"\r\nContent-ID: <doc.txt>\r\nContent-Transfer-Encoding: base64\r\nContent-Type: text/plain; charset=utf-8;\r\n\tfilename=\"doc.txt\"; name=\"doc.txt\"\r\n\r\nZG9j\r\n" +
+		"--mixed--\r\n"
+
+	buf := &bytes.Buffer{}
+	if err := m.buildMimeWithBoundaries(buf, "mixed", "alt", "related"); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.String() != want {
+		t.Errorf("MailYak.buildMime() = %q, want %q", buf.String(), want)
+	}
+}
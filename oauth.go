@@ -0,0 +1,210 @@
+package mailyak
+
+import (
+	"errors"
+	"net/smtp"
+	"sync"
+	"time"
+)
+
+// OAuthToken is an OAuth 2.0 access token used to authenticate with the SMTP
+// server.
+type OAuthToken struct {
+	// AccessToken is the bearer token sent to the SMTP server.
+	AccessToken string
+
+	// Expiry is the time the token expires, or the zero time if the token
+	// does not expire.
+	Expiry time.Time
+}
+
+// OAuthTokenSource supplies the OAuth 2.0 access tokens used by XOAuth2Auth
+// and OAuthBearerAuth.
+//
+// Token is called to obtain a new access token when no token has been
+// obtained, the previous token has expired, or the SMTP server rejected the
+// previous token - it should refresh the token rather than return a cached
+// one.
+//
+// Token may be called concurrently when sending emails concurrently.
+type OAuthTokenSource interface {
+	Token() (*OAuthToken, error)
+}
+
+// OAuthTokenSourceFunc is an adapter allowing a function to be used as an
+// OAuthTokenSource, such as to wrap a golang.org/x/oauth2 TokenSource:
+//
+//	src := mailyak.OAuthTokenSourceFunc(func() (*mailyak.OAuthToken, error) {
+//	    t, err := oauthConfig.TokenSource(ctx, refreshToken).Token()
+//	    if err != nil {
+//	        return nil, err
+//	    }
+//	    return &mailyak.OAuthToken{AccessToken: t.AccessToken, Expiry: t.Expiry}, nil
+//	})
+type OAuthTokenSourceFunc func() (*OAuthToken, error)
+
+// Token calls f.
+func (f OAuthTokenSourceFunc) Token() (*OAuthToken, error) {
+	return f()
+}
+
+// tokenExpiryDelta is how long before its expiry time a token is considered
+// expired, allowing for clock skew and the time taken to send the token.
+const tokenExpiryDelta = 30 * time.Second
+
+// oauthAuth implements the XOAUTH2 and OAUTHBEARER SASL mechanisms, caching
+// the access token obtained from an OAuthTokenSource until it expires or is
+// rejected by the server.
+type oauthAuth struct {
+	mechanism string
+	username  string
+	source    OAuthTokenSource
+
+	mu    sync.Mutex
+	token *OAuthToken
+}
+
+// XOAuth2Auth returns an smtp.Auth that implements the XOAUTH2 authentication
+// mechanism used by Gmail and Microsoft 365, authenticating as username with
+// access tokens obtained from src:
+//
+//	mail := mailyak.New("smtp.gmail.com:587", mailyak.XOAuth2Auth("dom@itsallbroken.com", src))
+//
+// The access token is reused until it expires. If the server rejects the
+// token, the token is discarded and the authentication is retried once on a
+// new connection with a new token from src.
+//
+// As with smtp.PlainAuth, the token is only sent over TLS connections, or to
+// localhost.
+func XOAuth2Auth(username string, src OAuthTokenSource) smtp.Auth {
+	return &oauthAuth{
+		mechanism: "XOAUTH2",
+		username:  username,
+		source:    src,
+	}
+}
+
+// OAuthBearerAuth returns an smtp.Auth that implements the OAUTHBEARER
+// authentication mechanism (RFC 7628), authenticating as username with access
+// tokens obtained from src.
+//
+// The token is reused and refreshed as described in XOAuth2Auth.
+func OAuthBearerAuth(username string, src OAuthTokenSource) smtp.Auth {
+	return &oauthAuth{
+		mechanism: "OAUTHBEARER",
+		username:  username,
+		source:    src,
+	}
+}
+
+func (a *oauthAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	// Mirror the behaviour of smtp.PlainAuth, refusing to send credentials
+	// over an unencrypted connection to a remote server.
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	token, err := a.accessToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if a.mechanism == "XOAUTH2" {
+		return a.mechanism, []byte("user=" + a.username + "\x01auth=Bearer " + token + "\x01\x01"), nil
+	}
+
+	// The GS2 header and key/value pairs of RFC 7628 section 3.1.
+	return a.mechanism, []byte("n,a=" + gs2Name(a.username) + ",\x01host=" + server.Name + "\x01auth=Bearer " + token + "\x01\x01"), nil
+}
+
+func (a *oauthAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	// The server rejected the token, and sent a challenge containing the
+	// error details. The client must respond to complete the exchange (with
+	// an empty response for XOAUTH2, and a single %x01 for OAUTHBEARER) before
+	// the server sends the failure reply.
+	a.invalidateToken()
+
+	if a.mechanism == "XOAUTH2" {
+		return []byte{}, nil
+	}
+	return []byte{0x01}, nil
+}
+
+// accessToken returns the cached access token, obtaining a new token from the
+// source if there is no cached token or it has expired.
+func (a *oauthAuth) accessToken() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.token != nil && (a.token.Expiry.IsZero() || time.Now().Add(tokenExpiryDelta).Before(a.token.Expiry)) {
+		return a.token.AccessToken, nil
+	}
+
+	token, err := a.source.Token()
+	if err != nil {
+		return "", err
+	}
+	if token == nil || token.AccessToken == "" {
+		return "", errors.New("mailyak: oauth token source returned an empty token")
+	}
+
+	a.token = token
+	return token.AccessToken, nil
+}
+
+// invalidateToken discards the cached access token, causing a new token to be
+// obtained for the next authentication.
+func (a *oauthAuth) invalidateToken() {
+	a.mu.Lock()
+	a.token = nil
+	a.mu.Unlock()
+}
+
+// gs2Name escapes the SASL authorization identity name for use in a GS2
+// header (RFC 5801 section 4).
+func gs2Name(name string) string {
+	var out []byte
+	for i := 0; i < len(name); i++ {
+		switch name[i] {
+		case ',':
+			out = append(out, "=2C"...)
+		case '=':
+			out = append(out, "=3D"...)
+		default:
+			out = append(out, name[i])
+		}
+	}
+	return string(out)
+}
+
+// retryTokenAuth calls send, and if it fails because the SMTP server rejected
+// the OAuth access token provided by auth, calls send once more so a new
+// connection is authenticated with a new token.
+//
+// A rejected AUTH command causes the net/smtp client to close the connection,
+// so send must establish a new connection each time it is called.
+func retryTokenAuth(auth smtp.Auth, send func() error) error {
+	err := send()
+
+	oauth, ok := auth.(*oauthAuth)
+	if !ok || !isAuthRejected(err) {
+		return err
+	}
+
+	// The token may have been rejected without a challenge, in which case it
+	// has not yet been discarded.
+	oauth.invalidateToken()
+
+	return send()
+}
+
+// isAuthRejected returns true if err is a permanent failure reply to the AUTH
+// command.
+func isAuthRejected(err error) bool {
+	var smtpErr *SMTPError
+	return errors.As(err, &smtpErr) && smtpErr.Stage == StageAuth && smtpErr.Code >= 500 && smtpErr.Code < 600
+}
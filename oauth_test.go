@@ -0,0 +1,261 @@
+package mailyak
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTokenSource returns a new numbered token each time it is called.
+type testTokenSource struct {
+	mu     sync.Mutex
+	calls  int
+	expiry time.Time
+}
+
+func (s *testTokenSource) Token() (*OAuthToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	return &OAuthToken{
+		AccessToken: fmt.Sprintf("token-%d", s.calls),
+		Expiry:      s.expiry,
+	}, nil
+}
+
+// TestOAuthAuth ensures the XOAUTH2 and OAUTHBEARER initial responses and
+// error challenge responses are correctly formed.
+func TestOAuthAuth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		auth        func(src OAuthTokenSource) smtp.Auth
+		wantMech    string
+		wantResp    string
+		wantErrResp []byte
+	}{
+		{
+			name:        "xoauth2",
+			auth:        func(src OAuthTokenSource) smtp.Auth { return XOAuth2Auth("dom@itsallbroken.com", src) },
+			wantMech:    "XOAUTH2",
+			wantResp:    "user=dom@itsallbroken.com\x01auth=Bearer token-1\x01\x01",
+			wantErrResp: []byte{},
+		},
+		{
+			name:        "oauthbearer",
+			auth:        func(src OAuthTokenSource) smtp.Auth { return OAuthBearerAuth("dom,=@itsallbroken.com", src) },
+			wantMech:    "OAUTHBEARER",
+			wantResp:    "n,a=dom=2C=3D@itsallbroken.com,\x01host=localhost\x01auth=Bearer token-1\x01\x01",
+			wantErrResp: []byte{0x01},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			src := &testTokenSource{}
+			auth := tt.auth(src)
+
+			mech, resp, err := auth.Start(&smtp.ServerInfo{Name: "localhost"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mech != tt.wantMech {
+				t.Errorf("mechanism = %q, want %q", mech, tt.wantMech)
+			}
+			if string(resp) != tt.wantResp {
+				t.Errorf("initial response = %q, want %q", resp, tt.wantResp)
+			}
+
+			// The token is reused while valid.
+			if _, _, err := auth.Start(&smtp.ServerInfo{Name: "localhost"}); err != nil {
+				t.Fatal(err)
+			}
+			if src.calls != 1 {
+				t.Fatalf("token source called %d times, want 1", src.calls)
+			}
+
+			// An error challenge discards the token.
+			got, err := auth.Next([]byte(`{"status":"401"}`), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != string(tt.wantErrResp) || got == nil {
+				t.Errorf("error challenge response = %q, want %q", got, tt.wantErrResp)
+			}
+			if _, _, err := auth.Start(&smtp.ServerInfo{Name: "localhost"}); err != nil {
+				t.Fatal(err)
+			}
+			if src.calls != 2 {
+				t.Fatalf("token source called %d times, want 2", src.calls)
+			}
+
+			// Success replies require no response.
+			if got, err := auth.Next([]byte("Accepted"), false); got != nil || err != nil {
+				t.Errorf("Next() = %q, %v, want nil", got, err)
+			}
+		})
+	}
+}
+
+// TestOAuthAuth_expiry ensures expired tokens are refreshed.
+func TestOAuthAuth_expiry(t *testing.T) {
+	t.Parallel()
+
+	src := &testTokenSource{expiry: time.Now().Add(10 * time.Second)}
+	auth := XOAuth2Auth("user", src)
+
+	for i := 1; i <= 2; i++ {
+		_, resp, err := auth.Start(&smtp.ServerInfo{Name: "127.0.0.1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("user=user\x01auth=Bearer token-%d\x01\x01", i); string(resp) != want {
+			t.Errorf("initial response = %q, want %q", resp, want)
+		}
+	}
+}
+
+// TestOAuthAuth_errors ensures tokens are not sent over unencrypted
+// connections, and token source errors are returned.
+func TestOAuthAuth_errors(t *testing.T) {
+	t.Parallel()
+
+	if _, _, err := XOAuth2Auth("user", &testTokenSource{}).Start(&smtp.ServerInfo{Name: "smtp.example.org"}); err == nil {
+		t.Error("expected error for unencrypted connection")
+	}
+	if _, _, err := XOAuth2Auth("user", &testTokenSource{}).Start(&smtp.ServerInfo{Name: "smtp.example.org", TLS: true}); err != nil {
+		t.Errorf("unexpected error for TLS connection: %v", err)
+	}
+
+	wantErr := errors.New("refresh failed")
+	src := OAuthTokenSourceFunc(func() (*OAuthToken, error) { return nil, wantErr })
+	if _, _, err := OAuthBearerAuth("user", src).Start(&smtp.ServerInfo{Name: "localhost"}); !errors.Is(err, wantErr) {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+}
+
+// TestOAuthAuth_retry ensures a rejected token is refreshed, and the
+// authentication retried once on a new connection.
+func TestOAuthAuth_retry(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	authResp := func(token string) string {
+		return "AUTH XOAUTH2 " + base64.StdEncoding.EncodeToString([]byte("user=user\x01auth=Bearer "+token+"\x01\x01")) + "\r\n"
+	}
+
+	// Each connection is handled by the next func.
+	handlers := []func(c *connAsserts){
+		// The first token is rejected with an error challenge.
+		func(c *connAsserts) {
+			c.Expect(authResp("token-1"))
+			c.Respond("334 eyJzdGF0dXMiOiI0MDEifQ==\r\n")
+			c.Expect("\r\n")
+			c.Respond("535 5.7.8 Username and Password not accepted\r\n")
+			c.Expect("*\r\n")
+			c.Respond("501 Aborted\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+		// The refreshed token is accepted.
+		func(c *connAsserts) {
+			c.Expect(authResp("token-2"))
+			c.Respond("235 Accepted\r\n")
+
+			c.Expect("MAIL FROM:<from@example.org>\r\n")
+			c.Respond("250 OK\r\n")
+			c.Expect("RCPT TO:<to@example.org>\r\n")
+			c.Respond("250 OK\r\n")
+			c.Expect("DATA\r\n")
+			c.Respond("354 OK\r\n")
+			c.Expect("bananas\r\n.\r\n")
+			c.Respond("250 Will do friend\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+		// The refreshed token is rejected without a challenge for the second
+		// send, and the retry fails too.
+		func(c *connAsserts) {
+			c.Expect(authResp("token-2"))
+			c.Respond("535 5.7.8 Expired\r\n")
+			c.Expect("*\r\n")
+			c.Respond("501 Aborted\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+		func(c *connAsserts) {
+			c.Expect(authResp("token-3"))
+			c.Respond("535 5.7.8 Still no\r\n")
+			c.Expect("*\r\n")
+			c.Respond("501 Aborted\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+	}
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+
+		for _, fn := range handlers {
+			conn, err := socket.Accept()
+			if err != nil {
+				panic(err)
+			}
+
+			c := newConnAsserts(conn, t)
+			c.Respond("220 localhost ESMTP bananas\r\n")
+			c.Expect("EHLO localhost\r\n")
+			c.Respond("250-localhost Hola\r\n")
+			c.Respond("250 AUTH XOAUTH2 OAUTHBEARER\r\n")
+
+			fn(c)
+			conn.Close()
+		}
+	}()
+
+	src := &testTokenSource{}
+	mail := &mockMail{
+		toAddrs:  []string{"to@example.org"},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+		auth:     XOAuth2Auth("user", src),
+	}
+
+	sender := newSenderWithStartTLS(socket.Addr().String())
+	if err := sender.Send(context.Background(), mail); err != nil {
+		t.Fatal(err)
+	}
+
+	err = sender.Send(context.Background(), mail)
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Stage != StageAuth || smtpErr.Code != 535 {
+		t.Fatalf("got %v, want auth error", err)
+	}
+
+	select {
+	case <-handlerDone:
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for SMTP conversation to complete")
+	}
+
+	if src.calls != 3 {
+		t.Errorf("token source called %d times, want 3", src.calls)
+	}
+}
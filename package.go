@@ -9,8 +9,9 @@
 // that implements io.Reader suitable as a source (like files on disk, in-memory
 // buffers, etc).
 //
-// The raw MIME content can be retrieved using MimeBuf(), typically used with an
-// API service such as Amazon SES that does not require using an SMTP interface.
+// The raw MIME content can be retrieved using MimeBuf(), or streamed using
+// WriteTo() or Reader(), typically used with an API service such as Amazon SES
+// that does not require using an SMTP interface.
 //
 // MailYak supports both plain-text SMTP (which is automatically upgraded to a
 // secure connection with STARTTLS if supported by the SMTP server) and explicit
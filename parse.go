@@ -0,0 +1,272 @@
+package mailyak
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// parsedHeaders are the headers populated by ReadMIME using the corresponding
+// setters, or that are regenerated when the email is built, and are therefore
+// not copied as custom headers.
+var parsedHeaders = map[string]bool{
+	"From":                      true,
+	"To":                        true,
+	"Cc":                        true,
+	"Bcc":                       true,
+	"Reply-To":                  true,
+	"Subject":                   true,
+	"Date":                      true,
+	"Mime-Version":              true,
+	"Content-Type":              true,
+	"Content-Transfer-Encoding": true,
+	"Dkim-Signature":            true,
+	"Message-Id":                true,
+	"In-Reply-To":               true,
+	"References":                true,
+
+	// Trace and delivery headers added by the servers that handled the
+	// email, which do not apply when it is re-sent.
+	"Received":                   true,
+	"Received-Spf":               true,
+	"X-Received":                 true,
+	"Return-Path":                true,
+	"Delivered-To":               true,
+	"X-Original-To":              true,
+	"Authentication-Results":     true,
+	"Arc-Seal":                   true,
+	"Arc-Message-Signature":      true,
+	"Arc-Authentication-Results": true,
+}
+
+// headerDecoder decodes RFC 2047 encoded-words in header values.
+var headerDecoder = &mime.WordDecoder{}
+
+// ReadMIME parses the RFC 5322 / MIME email read from r, replacing the
+// addresses, subject, headers, body parts and attachments of m with those in
+// the parsed email.
+//
+// This allows an email previously generated by MimeBuf() to be modified or
+// re-sent:
+//
+//	mail := mailyak.New("mail.host.com:25", auth)
+//	if err := mail.ReadMIME(storedEmail); err != nil {
+//	    return err
+//	}
+//	mail.Subject("Re-sent: " + subject)
+//	err := mail.Send()
+//
+// The text/plain and text/html parts without a filename are read into Plain()
+// and HTML(), and all other parts are added as attachments (or inline
+// attachments if they have an inline disposition or a Content-ID) with their
+// MIME type preserved. Any RFC 2047 encoded subject, names and filenames are
+// decoded.
+//
+// Body parts using the ISO-8859-1 charset are converted to UTF-8, and an error
+// is returned for body parts using any charset other than UTF-8, US-ASCII or
+// ISO-8859-1.
+//
+// Trace headers added by the servers that delivered the email (such as
+// Received, Return-Path and Authentication-Results) are discarded.
+//
+// The Message-ID is preserved, so re-sending the parsed email sends the same
+// message - call SetMessageID("") to generate a new ID. The Date header is not
+// preserved, and is set when the email is next sent.
+// The SMTP server, authentication and sending options of m are unchanged.
+func (m *MailYak) ReadMIME(r io.Reader) error {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return err
+	}
+
+	// Parse the addresses before modifying m, so an invalid email leaves m
+	// unchanged.
+	addrs := map[string][]*mail.Address{}
+	for _, name := range []string{"From", "To", "Cc", "Bcc"} {
+		if msg.Header.Get(name) == "" {
+			continue
+		}
+
+		list, err := msg.Header.AddressList(name)
+		if err != nil {
+			return fmt.Errorf("mailyak: invalid %s header: %w", name, err)
+		}
+		addrs[name] = list
+	}
+
+	subject, err := headerDecoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return fmt.Errorf("mailyak: invalid Subject header: %w", err)
+	}
+
+	// Read the body parts and attachments into a new instance, copying them
+	// to m once the whole email has been parsed successfully.
+	parsed := &MailYak{}
+	if err := parsed.readEntity(textproto.MIMEHeader(msg.Header), msg.Body); err != nil {
+		return err
+	}
+
+	m.To(addressStrings(addrs["To"])...)
+	m.Cc(addressStrings(addrs["Cc"])...)
+	m.Bcc(addressStrings(addrs["Bcc"])...)
+
+	m.fromAddr, m.fromName = "", ""
+	if from := addrs["From"]; len(from) > 0 {
+		m.From(from[0].Address)
+		if from[0].Name != "" {
+			m.FromName(from[0].Name)
+		}
+	}
+
+	m.ReplyTo(msg.Header.Get("Reply-To"))
+	m.Subject(subject)
+
+	m.SetMessageID(msg.Header.Get("Message-Id"))
+	m.InReplyTo(strings.Fields(msg.Header.Get("In-Reply-To"))...)
+	m.References(strings.Fields(msg.Header.Get("References"))...)
+
+	// The parsed header order is not preserved, so the custom headers are added
+	// in name order to keep the output consistent.
+	names := make([]string, 0, len(msg.Header))
+	for name := range msg.Header {
+		if !parsedHeaders[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	m.headers, m.headerOrder = map[string][]string{}, nil
+	for _, name := range names {
+		for _, v := range msg.Header[name] {
+			if decoded, err := headerDecoder.DecodeHeader(v); err == nil {
+				v = decoded
+			}
+			m.AddHeader(name, v)
+		}
+	}
+
+	m.plain.Set(parsed.plain.String())
+	m.html.Set(parsed.html.String())
+	m.attachments = parsed.attachments
+
+	return nil
+}
+
+// readEntity reads the MIME entity with the given header and body, recursing
+// into any multipart content.
+func (m *MailYak) readEntity(header textproto.MIMEHeader, body io.Reader) error {
+	ctype := header.Get("Content-Type")
+	if ctype == "" {
+		ctype = "text/plain"
+	}
+
+	mediaType, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return fmt.Errorf("mailyak: invalid Content-Type %q: %w", ctype, err)
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			if err := m.readEntity(part.Header, part); err != nil {
+				return err
+			}
+		}
+	}
+
+	data, err := ioutil.ReadAll(transferDecoder(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return err
+	}
+
+	var (
+		disposition, dispParams, _ = mime.ParseMediaType(header.Get("Content-Disposition"))
+		contentID                  = strings.Trim(header.Get("Content-ID"), "<>")
+	)
+
+	filename := dispParams["filename"]
+	if filename == "" {
+		filename = params["name"]
+	}
+	if decoded, err := headerDecoder.DecodeHeader(filename); err == nil {
+		filename = decoded
+	}
+
+	// Text parts without a filename or attachment disposition are the body -
+	// many clients mark the body parts with an inline disposition.
+	isBody := (disposition == "" || disposition == "inline") && filename == "" && contentID == ""
+	if isBody && (mediaType == "text/plain" || mediaType == "text/html") {
+		data, err = decodeCharset(params["charset"], data)
+		if err != nil {
+			return err
+		}
+
+		if mediaType == "text/plain" {
+			_, err = m.plain.Write(data)
+		} else {
+			_, err = m.html.Write(data)
+		}
+		return err
+	}
+
+	inline := disposition == "inline" || (disposition == "" && contentID != "")
+
+	// Inline attachments are referenced by their Content-ID, which MailYak
+	// derives from the filename - use it if the part is not named.
+	if inline && filename == "" {
+		filename = contentID
+	}
+
+	m.attachments = append(m.attachments, newReaderAttachment(filename, bytes.NewReader(data), inline, mediaType))
+
+	return nil
+}
+
+// transferDecoder returns a reader decoding r according to the
+// Content-Transfer-Encoding cte.
+func transferDecoder(cte string, r io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r)
+	case "quoted-printable":
+		return quotedprintable.NewReader(r)
+	}
+	return r
+}
+
+// decodeCharset returns the text in data, encoded using charset, as UTF-8.
+//
+// UTF-8, US-ASCII and ISO-8859-1 are supported - an error is returned for any
+// other charset, rather than re-sending the text mislabelled as UTF-8.
+func decodeCharset(charset string, data []byte) ([]byte, error) {
+	switch strings.ToLower(charset) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return data, nil
+	case "iso-8859-1", "iso8859-1", "latin1":
+		// Each ISO-8859-1 byte is the Unicode code point of the character.
+		var buf bytes.Buffer
+		for _, b := range data {
+			buf.WriteRune(rune(b))
+		}
+		return buf.Bytes(), nil
+	}
+
+	return nil, fmt.Errorf("mailyak: unsupported charset %q", charset)
+}
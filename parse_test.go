@@ -0,0 +1,337 @@
+package mailyak
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestMailYakReadMIME_roundTrip ensures an email generated by MailYak is
+// reconstructed by ReadMIME.
+func TestMailYakReadMIME_roundTrip(t *testing.T) {
+	t.Parallel()
+
+	orig := New("mail.host.com:25", nil)
+	orig.From("from@itsallbroken.com")
+	orig.FromName("Dom Dwyer ✉")
+	orig.To("to@example.org", "Friend <friend@example.org>")
+	orig.Cc("cc@example.org")
+	orig.Bcc("bcc@example.org")
+	orig.WriteBccHeader(true)
+	orig.ReplyTo("reply@itsallbroken.com")
+	orig.Subject("Test ✓ subject")
+	orig.AddHeader("X-Custom", "bananas")
+	orig.InReplyTo("parent@itsallbroken.com")
+	orig.References("root@itsallbroken.com", "parent@itsallbroken.com")
+	orig.Plain().Set("Plain body")
+	orig.HTML().Set("<p>HTML body ✓</p><img src=\"cid:" + ContentID("Logo März.png") + "\">")
+	orig.Attach("Bericht März.csv", strings.NewReader("a,b,c"))
+	orig.AttachWithMimeType("data.json", strings.NewReader(`{"a": 1}`), "application/json")
+	orig.AttachInline("Logo März.png", bytes.NewReader([]byte("\x89PNG\r\n\x1a\nfake image")))
+
+	buf, err := orig.MimeBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := New("mail.host.com:25", nil)
+	got.Attach("old.txt", strings.NewReader("replaced"))
+	if err := got.ReadMIME(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	if got.fromAddr != orig.fromAddr {
+		t.Errorf("fromAddr = %q, want %q", got.fromAddr, orig.fromAddr)
+	}
+	if got.fromName != orig.fromName {
+		t.Errorf("fromName = %q, want %q", got.fromName, orig.fromName)
+	}
+	if want := []string{"to@example.org", "\"Friend\" <friend@example.org>"}; !reflect.DeepEqual(got.toAddrs, want) {
+		t.Errorf("toAddrs = %q, want %q", got.toAddrs, want)
+	}
+	if want := []string{"cc@example.org"}; !reflect.DeepEqual(got.ccAddrs, want) {
+		t.Errorf("ccAddrs = %q, want %q", got.ccAddrs, want)
+	}
+	if want := []string{"bcc@example.org"}; !reflect.DeepEqual(got.bccAddrs, want) {
+		t.Errorf("bccAddrs = %q, want %q", got.bccAddrs, want)
+	}
+	if !reflect.DeepEqual(got.getToAddrs(), orig.getToAddrs()) {
+		t.Errorf("getToAddrs() = %q, want %q", got.getToAddrs(), orig.getToAddrs())
+	}
+	if got.replyTo != orig.replyTo {
+		t.Errorf("replyTo = %q, want %q", got.replyTo, orig.replyTo)
+	}
+	if got.subject != orig.subject {
+		t.Errorf("subject = %q, want %q", got.subject, orig.subject)
+	}
+	if got.MessageID() != orig.MessageID() {
+		t.Errorf("MessageID() = %q, want %q", got.MessageID(), orig.MessageID())
+	}
+	if want := []string{"<parent@itsallbroken.com>"}; !reflect.DeepEqual(got.inReplyTo, want) {
+		t.Errorf("inReplyTo = %q, want %q", got.inReplyTo, want)
+	}
+	if want := []string{"<root@itsallbroken.com>", "<parent@itsallbroken.com>"}; !reflect.DeepEqual(got.references, want) {
+		t.Errorf("references = %q, want %q", got.references, want)
+	}
+	if want := map[string][]string{"X-Custom": {"bananas"}}; !reflect.DeepEqual(got.headers, want) {
+		t.Errorf("headers = %v, want %v", got.headers, want)
+	}
+	if got.Plain().String() != "Plain body" {
+		t.Errorf("plain = %q, want %q", got.Plain().String(), "Plain body")
+	}
+	if got.HTML().String() != orig.HTML().String() {
+		t.Errorf("html = %q, want %q", got.HTML().String(), orig.HTML().String())
+	}
+
+	wantAttachments := []struct {
+		filename string
+		content  string
+		inline   bool
+		mimeType string
+	}{
+		{"Logo März.png", "\x89PNG\r\n\x1a\nfake image", true, "image/png"},
+		{"Bericht März.csv", "a,b,c", false, "text/plain"},
+		{"data.json", `{"a": 1}`, false, "application/json"},
+	}
+	if len(got.attachments) != len(wantAttachments) {
+		t.Fatalf("got %d attachments, want %d", len(got.attachments), len(wantAttachments))
+	}
+	for i, want := range wantAttachments {
+		a := got.attachments[i]
+		r, err := a.reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.filename != want.filename || a.inline != want.inline || a.mimeType != want.mimeType || string(content) != want.content {
+			t.Errorf("attachment %d = {%q, %q, %v, %q}, want %+v", i, a.filename, content, a.inline, a.mimeType, want)
+		}
+	}
+}
+
+// TestMailYakReadMIME ensures external emails are parsed.
+func TestMailYakReadMIME(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		msg  string
+
+		wantFrom     string
+		wantFromName string
+		wantTo       []string
+		wantSubject  string
+		wantPlain    string
+		wantHTML     string
+		wantFiles    []string
+		wantHeaders  map[string][]string
+		wantErr      bool
+	}{
+		{
+			name: "single part",
+			msg: "From: sender@example.org\r\n" +
+				"To: a@example.org, \"B, Person\" <b@example.org>\r\n" +
+				"Subject: =?ISO-8859-1?Q?Caf=E9?=\r\n" +
+				"\r\n" +
+				"Hello\r\n",
+			wantFrom:    "sender@example.org",
+			wantTo:      []string{"a@example.org", "\"B, Person\" <b@example.org>"},
+			wantSubject: "=?UTF-8?q?Caf=C3=A9?=",
+			wantPlain:   "Hello\r\n",
+		},
+		{
+			name: "encoded single part",
+			msg: "From: =?UTF-8?B?w4lsb2lzZQ==?= <sender@example.org>\r\n" +
+				"Subject: Plain subject\r\n" +
+				"Content-Type: text/html; charset=UTF-8\r\n" +
+				"Content-Transfer-Encoding: base64\r\n" +
+				"\r\n" +
+				"PGI+SGk8L2I+\r\n",
+			wantFrom:     "sender@example.org",
+			wantFromName: "=?UTF-8?q?=C3=89loise?=",
+			wantSubject:  "Plain subject",
+			wantHTML:     "<b>Hi</b>",
+		},
+		{
+			name: "nested multipart",
+			msg: "From: sender@example.org\r\n" +
+				"Content-Type: multipart/mixed; boundary=outer\r\n" +
+				"\r\n" +
+				"--outer\r\n" +
+				"Content-Type: multipart/alternative; boundary=inner\r\n" +
+				"\r\n" +
+				"--inner\r\n" +
+				"Content-Type: text/plain\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"Caf=C3=A9\r\n" +
+				"--inner\r\n" +
+				"Content-Type: text/html\r\n" +
+				"\r\n" +
+				"<p>Caf\xc3\xa9</p>\r\n" +
+				"--inner--\r\n" +
+				"--outer\r\n" +
+				"Content-Type: application/pdf\r\n" +
+				"Content-Disposition: attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.pdf\r\n" +
+				"\r\n" +
+				"pdf\r\n" +
+				"--outer\r\n" +
+				"Content-Type: text/plain; name=\"=?UTF-8?Q?n=C3=B6tes.txt?=\"\r\n" +
+				"Content-Disposition: attachment\r\n" +
+				"\r\n" +
+				"notes\r\n" +
+				"--outer--\r\n",
+			wantFrom:  "sender@example.org",
+			wantPlain: "Café",
+			wantHTML:  "<p>Café</p>",
+			wantFiles: []string{"résumé.pdf", "nötes.txt"},
+		},
+		{
+			name: "inline body parts",
+			msg: "From: sender@example.org\r\n" +
+				"Content-Type: multipart/mixed; boundary=outer\r\n" +
+				"\r\n" +
+				"--outer\r\n" +
+				"Content-Type: text/plain; charset=us-ascii\r\n" +
+				"Content-Disposition: inline\r\n" +
+				"\r\n" +
+				"Hello\r\n" +
+				"--outer\r\n" +
+				"Content-Type: image/png\r\n" +
+				"Content-Disposition: inline; filename=cat.png\r\n" +
+				"\r\n" +
+				"png\r\n" +
+				"--outer--\r\n",
+			wantFrom:  "sender@example.org",
+			wantPlain: "Hello",
+			wantFiles: []string{"cat.png"},
+		},
+		{
+			name: "delivered email",
+			msg: "Return-Path: <bounce@example.org>\r\n" +
+				"Delivered-To: to@example.org\r\n" +
+				"Received: from mx.example.org by mail.example.org; Mon, 1 Jan 2024 00:00:00 +0000\r\n" +
+				"Received: from client by mx.example.org; Mon, 1 Jan 2024 00:00:00 +0000\r\n" +
+				"Authentication-Results: mail.example.org; spf=pass\r\n" +
+				"ARC-Seal: i=1; a=rsa-sha256; cv=none; d=example.org; s=arc; b=c2ln\r\n" +
+				"X-Original-To: to@example.org\r\n" +
+				"X-Custom: bananas\r\n" +
+				"From: sender@example.org\r\n" +
+				"Content-Type: multipart/related; boundary=outer\r\n" +
+				"\r\n" +
+				"--outer\r\n" +
+				"Content-Type: text/html\r\n" +
+				"\r\n" +
+				"<img src=\"cid:logo@example.org\">\r\n" +
+				"--outer\r\n" +
+				"Content-Type: image/png\r\n" +
+				"Content-Disposition: inline; filename=logo.png\r\n" +
+				"Content-ID: <logo@example.org>\r\n" +
+				"\r\n" +
+				"png\r\n" +
+				"--outer\r\n" +
+				"Content-Type: image/png\r\n" +
+				"Content-ID: <banner.png>\r\n" +
+				"\r\n" +
+				"png\r\n" +
+				"--outer--\r\n",
+			wantFrom:    "sender@example.org",
+			wantHTML:    "<img src=\"cid:logo@example.org\">",
+			wantFiles:   []string{"logo.png", "banner.png"},
+			wantHeaders: map[string][]string{"X-Custom": {"bananas"}},
+		},
+		{
+			name: "iso-8859-1 body",
+			msg: "From: sender@example.org\r\n" +
+				"Content-Type: text/plain; charset=ISO-8859-1\r\n" +
+				"Content-Transfer-Encoding: quoted-printable\r\n" +
+				"\r\n" +
+				"Caf=E9\r\n",
+			wantFrom:  "sender@example.org",
+			wantPlain: "Café\r\n",
+		},
+		{
+			name: "unsupported charset",
+			msg: "From: sender@example.org\r\n" +
+				"Content-Type: text/plain; charset=Shift_JIS\r\n" +
+				"\r\n" +
+				"\x82\xa0\r\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid address",
+			msg:     "From: not an address\r\n\r\nbody",
+			wantErr: true,
+		},
+		{
+			name:    "invalid content type",
+			msg:     "From: a@example.org\r\nContent-Type: multipart/mixed; boundary=\"\r\n\r\nbody",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := New("mail.host.com:25", nil)
+			m.Subject("unchanged")
+
+			err := m.ReadMIME(strings.NewReader(tt.msg))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if m.subject != "unchanged" {
+					t.Error("email modified after parse error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if m.fromAddr != tt.wantFrom {
+				t.Errorf("fromAddr = %q, want %q", m.fromAddr, tt.wantFrom)
+			}
+			if m.fromName != tt.wantFromName {
+				t.Errorf("fromName = %q, want %q", m.fromName, tt.wantFromName)
+			}
+			if len(m.toAddrs) != 0 || len(tt.wantTo) != 0 {
+				if !reflect.DeepEqual(m.toAddrs, tt.wantTo) {
+					t.Errorf("toAddrs = %q, want %q", m.toAddrs, tt.wantTo)
+				}
+			}
+			if m.subject != tt.wantSubject {
+				t.Errorf("subject = %q, want %q", m.subject, tt.wantSubject)
+			}
+			if m.Plain().String() != tt.wantPlain {
+				t.Errorf("plain = %q, want %q", m.Plain().String(), tt.wantPlain)
+			}
+			if m.HTML().String() != tt.wantHTML {
+				t.Errorf("html = %q, want %q", m.HTML().String(), tt.wantHTML)
+			}
+
+			if len(m.headers) != 0 || len(tt.wantHeaders) != 0 {
+				if !reflect.DeepEqual(m.headers, tt.wantHeaders) {
+					t.Errorf("headers = %v, want %v", m.headers, tt.wantHeaders)
+				}
+			}
+
+			var files []string
+			for _, a := range m.attachments {
+				files = append(files, a.filename)
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("attachments = %q, want %q", files, tt.wantFiles)
+			}
+		})
+	}
+}
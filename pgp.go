@@ -0,0 +1,169 @@
+package mailyak
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PGPSigner creates detached OpenPGP signatures, allowing any OpenPGP
+// implementation to be used to sign PGP/MIME emails.
+type PGPSigner interface {
+	// MICAlg returns the name of the hash algorithm used to create
+	// signatures, as used in the multipart/signed micalg parameter (RFC 3156
+	// section 5) - for example "pgp-sha256".
+	MICAlg() string
+
+	// Sign returns an ASCII-armored detached signature of data.
+	Sign(data []byte) ([]byte, error)
+}
+
+// PGPEncryptor encrypts OpenPGP messages, allowing any OpenPGP implementation
+// to be used to encrypt PGP/MIME emails.
+type PGPEncryptor interface {
+	// Encrypt returns data encrypted as an ASCII-armored OpenPGP message.
+	Encrypt(data []byte) ([]byte, error)
+}
+
+// PGPOptions configures the PGP/MIME (RFC 3156) signing and/or encryption of
+// emails.
+//
+// If Signer is set the email is signed, and if Encryptor is set the email is
+// encrypted. When both are configured, the email is signed and then encrypted
+// (RFC 3156 section 6.1).
+type PGPOptions struct {
+	Signer    PGPSigner
+	Encryptor PGPEncryptor
+}
+
+// pgpWrapper wraps MIME entities in PGP/MIME structures according to a
+// validated PGPOptions.
+type pgpWrapper struct {
+	opts PGPOptions
+}
+
+// SetPGP configures m to sign and/or encrypt emails using PGP/MIME, wrapping
+// the email body and attachments in a multipart/signed or multipart/encrypted
+// entity:
+//
+//	err := mail.SetPGP(&mailyak.PGPOptions{
+//	    Signer:    signer,
+//	    Encryptor: encryptor,
+//	})
+//
+// The OpenPGP operations are performed by the provided PGPSigner and
+// PGPEncryptor, while MailYak assembles the MIME structure and ensures the
+// signed content is in canonical form.
+//
+// Passing nil disables PGP/MIME. PGP/MIME and S/MIME cannot be used together.
+//
+// When PGP/MIME is enabled the MIME content is buffered in memory so it can be
+// signed and encrypted before being sent.
+func (m *MailYak) SetPGP(opts *PGPOptions) error {
+	if opts == nil {
+		m.pgp = nil
+		return nil
+	}
+
+	if opts.Signer == nil && opts.Encryptor == nil {
+		return errors.New("mailyak: pgp requires a signer or encryptor")
+	}
+	if m.smime != nil {
+		return errors.New("mailyak: pgp cannot be used with s/mime")
+	}
+
+	m.pgp = &pgpWrapper{opts: *opts}
+	return nil
+}
+
+// wrap writes the PGP/MIME signed and/or encrypted form of the MIME entity to
+// w.
+func (p *pgpWrapper) wrap(w io.Writer, entity []byte) error {
+	// The entity must be in canonical form before signing, as any conversion
+	// in transit would invalidate the signature (RFC 3156 section 5).
+	entity = canonicalCRLF(entity)
+
+	if p.opts.Signer != nil {
+		buf := &bytes.Buffer{}
+		if err := p.writeSigned(buf, entity); err != nil {
+			return err
+		}
+		entity = buf.Bytes()
+	}
+
+	if p.opts.Encryptor == nil {
+		_, err := w.Write(entity)
+		return err
+	}
+
+	return p.writeEncrypted(w, entity)
+}
+
+// writeSigned writes a multipart/signed entity containing entity and a
+// detached OpenPGP signature.
+func (p *pgpWrapper) writeSigned(w io.Writer, entity []byte) error {
+	sig, err := p.opts.Signer.Sign(entity)
+	if err != nil {
+		return err
+	}
+
+	return writeMultipartSigned(w, entity, "application/pgp-signature", p.opts.Signer.MICAlg(), func(w io.Writer) error {
+		const header = "Content-Type: application/pgp-signature; name=\"signature.asc\"\r\n" +
+			"Content-Description: OpenPGP digital signature\r\n" +
+			"Content-Disposition: attachment; filename=\"signature.asc\"\r\n\r\n"
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		return writeArmored(w, sig)
+	})
+}
+
+// writeEncrypted writes a multipart/encrypted entity containing the encrypted
+// entity.
+func (p *pgpWrapper) writeEncrypted(w io.Writer, entity []byte) error {
+	encrypted, err := p.opts.Encryptor.Encrypt(entity)
+	if err != nil {
+		return err
+	}
+
+	boundary, err := randomBoundary()
+	if err != nil {
+		return err
+	}
+
+	// The headers, followed by the control part identifying the PGP/MIME
+	// version and the headers of the encrypted entity.
+	var b strings.Builder
+	fmt.Fprintf(&b, "Content-Type: multipart/encrypted;\r\n\tprotocol=\"application/pgp-encrypted\";\r\n\tboundary=\"%s\"\r\n\r\n", boundary)
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: application/pgp-encrypted\r\n")
+	b.WriteString("Content-Description: PGP/MIME version identification\r\n\r\n")
+	b.WriteString("Version: 1\r\n")
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	b.WriteString("Content-Type: application/octet-stream; name=\"encrypted.asc\"\r\n")
+	b.WriteString("Content-Description: OpenPGP encrypted message\r\n")
+	b.WriteString("Content-Disposition: inline; filename=\"encrypted.asc\"\r\n\r\n")
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return err
+	}
+
+	if err := writeArmored(w, encrypted); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "--%s--\r\n", boundary)
+	return err
+}
+
+// writeArmored writes the ASCII-armored data to w with CRLF line endings,
+// ensuring it ends with a CRLF.
+func writeArmored(w io.Writer, data []byte) error {
+	data = canonicalCRLF(bytes.TrimRight(data, "\r\n"))
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
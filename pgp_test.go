@@ -0,0 +1,278 @@
+package mailyak
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+// testPGPSigner is a PGPSigner that produces ed25519 signatures in an armored
+// block with bare LF line endings.
+type testPGPSigner struct {
+	key ed25519.PrivateKey
+
+	// signed records the data passed to Sign.
+	signed []byte
+}
+
+func (s *testPGPSigner) MICAlg() string { return "pgp-sha512" }
+
+func (s *testPGPSigner) Sign(data []byte) ([]byte, error) {
+	s.signed = append([]byte{}, data...)
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, data))
+	return []byte("-----BEGIN PGP SIGNATURE-----\n\n" + sig + "\n-----END PGP SIGNATURE-----\n"), nil
+}
+
+// testPGPEncryptor is a PGPEncryptor that "encrypts" by base64 encoding the
+// data.
+type testPGPEncryptor struct{}
+
+func (testPGPEncryptor) Encrypt(data []byte) ([]byte, error) {
+	return []byte("-----BEGIN PGP MESSAGE-----\n\n" + base64.StdEncoding.EncodeToString(data) + "\n-----END PGP MESSAGE-----"), nil
+}
+
+// decodeTestPGPMessage reverses testPGPEncryptor.Encrypt.
+func decodeTestPGPMessage(t *testing.T, armored []byte) []byte {
+	t.Helper()
+
+	lines := strings.Split(strings.TrimSpace(string(armored)), "\r\n")
+	if lines[0] != "-----BEGIN PGP MESSAGE-----" || lines[len(lines)-1] != "-----END PGP MESSAGE-----" {
+		t.Fatalf("invalid armored message %q", armored)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.Join(lines[1:len(lines)-1], ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// TestMailYakSetPGP ensures emails are correctly signed and/or encrypted with
+// PGP/MIME.
+func TestMailYakSetPGP(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		sign    bool
+		encrypt bool
+	}{
+		{name: "sign", sign: true},
+		{name: "encrypt", encrypt: true},
+		{name: "sign and encrypt", sign: true, encrypt: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pub, key, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var opts PGPOptions
+			signer := &testPGPSigner{key: key}
+			if tt.sign {
+				opts.Signer = signer
+			}
+			if tt.encrypt {
+				opts.Encryptor = testPGPEncryptor{}
+			}
+
+			m := newSMIMEMail(t)
+			if err := m.SetPGP(&opts); err != nil {
+				t.Fatal(err)
+			}
+
+			buf, err := m.MimeBuf()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header, body := readSMIMEEntity(t, buf.Bytes())
+
+			if tt.encrypt {
+				mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if mediaType != "multipart/encrypted" || params["protocol"] != "application/pgp-encrypted" {
+					t.Fatalf("unexpected content type %q", header.Get("Content-Type"))
+				}
+
+				r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+				control, err := r.NextPart()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := control.Header.Get("Content-Type"); got != "application/pgp-encrypted" {
+					t.Errorf("control part content type = %q", got)
+				}
+				version, _ := ioutil.ReadAll(control)
+				if string(version) != "Version: 1\r\n" {
+					t.Errorf("control part = %q, want %q", version, "Version: 1\r\n")
+				}
+
+				encrypted, err := r.NextPart()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := encrypted.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/octet-stream") {
+					t.Errorf("encrypted part content type = %q", got)
+				}
+				armored, _ := ioutil.ReadAll(encrypted)
+
+				header, body = readSMIMEEntity(t, decodeTestPGPMessage(t, armored))
+			}
+
+			if tt.sign {
+				mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if mediaType != "multipart/signed" || params["protocol"] != "application/pgp-signature" || params["micalg"] != "pgp-sha512" {
+					t.Fatalf("unexpected content type %q", header.Get("Content-Type"))
+				}
+
+				// The signed content must be exactly the data that was signed.
+				delim := "--" + params["boundary"] + "\r\n"
+				start := bytes.Index(body, []byte(delim)) + len(delim)
+				end := bytes.Index(body, []byte("\r\n"+delim))
+				content := body[start:end]
+				if !bytes.Equal(content, signer.signed) {
+					t.Fatalf("signed content mismatch:\ngot:  %q\nwant: %q", content, signer.signed)
+				}
+				if bytes.Contains(bytes.Replace(content, []byte("\r\n"), nil, -1), []byte("\n")) {
+					t.Error("signed content contains bare LF line endings")
+				}
+
+				r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+				if _, err := r.NextPart(); err != nil {
+					t.Fatal(err)
+				}
+				sigPart, err := r.NextPart()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := sigPart.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/pgp-signature") {
+					t.Errorf("signature part content type = %q", got)
+				}
+				armored, _ := ioutil.ReadAll(sigPart)
+				lines := strings.Split(string(armored), "\r\n")
+				sig, err := base64.StdEncoding.DecodeString(lines[2])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ed25519.Verify(pub, content, sig) {
+					t.Fatal("signature invalid")
+				}
+
+				header, body = readSMIMEEntity(t, content)
+			}
+
+			mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mediaType != "multipart/mixed" {
+				t.Errorf("inner content type = %q, want multipart/mixed", mediaType)
+			}
+			if !bytes.Contains(body, []byte("Secret")) || !bytes.Contains(body, []byte("test.txt")) {
+				t.Errorf("inner entity missing body or attachment:\n%s", body)
+			}
+		})
+	}
+}
+
+// failingPGPSigner is a PGPSigner that always returns err.
+type failingPGPSigner struct {
+	err error
+}
+
+func (s failingPGPSigner) MICAlg() string                   { return "pgp-sha256" }
+func (s failingPGPSigner) Sign(data []byte) ([]byte, error) { return nil, s.err }
+
+// TestMailYakSetPGP_errors ensures invalid configurations and signing errors
+// are returned.
+func TestMailYakSetPGP_errors(t *testing.T) {
+	t.Parallel()
+
+	m := newSMIMEMail(t)
+	if err := m.SetPGP(&PGPOptions{}); err == nil {
+		t.Fatal("expected error for empty options")
+	}
+
+	if err := m.SetSMIME(&SMIMEOptions{SignerCert: testCert, SignerKey: testRSAKey}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetPGP(&PGPOptions{Encryptor: testPGPEncryptor{}}); err == nil {
+		t.Fatal("expected error using pgp with s/mime")
+	}
+	if err := m.SetSMIME(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	wantErr := errors.New("no key")
+	if err := m.SetPGP(&PGPOptions{Signer: failingPGPSigner{err: wantErr}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetSMIME(&SMIMEOptions{Recipients: []*x509.Certificate{testCert}}); err == nil {
+		t.Fatal("expected error using s/mime with pgp")
+	}
+
+	if _, err := m.MimeBuf(); !errors.Is(err, wantErr) {
+		t.Fatalf("got %v, want %v", err, wantErr)
+	}
+}
+
+// TestPGPWrapper_writeError ensures a failed write of any part of the
+// PGP/MIME entity is returned.
+func TestPGPWrapper_writeError(t *testing.T) {
+	t.Parallel()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts PGPOptions
+	}{
+		{"signed", PGPOptions{Signer: &testPGPSigner{key: key}}},
+		{"encrypted", PGPOptions{Encryptor: testPGPEncryptor{}}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &pgpWrapper{opts: tt.opts}
+			entity := []byte("Content-Type: text/plain\r\n\r\nbananas\r\n")
+
+			// Count the writes made by wrap.
+			counter := &failingWriter{n: 1000}
+			if err := p.wrap(counter, entity); err != nil {
+				t.Fatal(err)
+			}
+			writes := 1000 - counter.n
+
+			for n := 0; n < writes; n++ {
+				if err := p.wrap(&failingWriter{n: n}, entity); err == nil {
+					t.Errorf("write %d failed without returning an error", n)
+				}
+			}
+		})
+	}
+}
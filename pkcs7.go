@@ -0,0 +1,336 @@
+package mailyak
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// Object identifiers used in the PKCS #7 / CMS (RFC 5652) structures.
+var (
+	oidData                   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidSHA256                 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRSAEncryption          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidECDSAWithSHA256        = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidAES256CBC              = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// asn1Null is the DER encoding of an ASN.1 NULL, used as the parameters of
+// some algorithm identifiers.
+var asn1Null = asn1.RawValue{Tag: asn1.TagNull}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7AlgorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+type pkcs7SignerInfo struct {
+	Version            int
+	SID                pkcs7IssuerAndSerial
+	DigestAlgorithm    pkcs7AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue
+	SignatureAlgorithm pkcs7AlgorithmIdentifier
+	Signature          []byte
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo pkcs7ContentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+type pkcs7RecipientInfo struct {
+	Version                int
+	RID                    pkcs7IssuerAndSerial
+	KeyEncryptionAlgorithm pkcs7AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type pkcs7EncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkcs7AlgorithmIdentifier
+	EncryptedContent           asn1.RawValue
+}
+
+type pkcs7EnvelopedData struct {
+	Version              int
+	RecipientInfos       asn1.RawValue
+	EncryptedContentInfo pkcs7EncryptedContentInfo
+}
+
+// derSet returns a DER SET OF the DER-encoded elems, sorted as required by
+// X.690 section 11.6.
+func derSet(elems ...[]byte) asn1.RawValue {
+	return derTagged(asn1.ClassUniversal, asn1.TagSet, elems...)
+}
+
+// derTagged returns a constructed value with the given class and tag
+// containing the DER-encoded elems in sorted order.
+func derTagged(class, tag int, elems ...[]byte) asn1.RawValue {
+	sorted := make([][]byte, len(elems))
+	copy(sorted, elems)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	return asn1.RawValue{
+		Class:      class,
+		Tag:        tag,
+		IsCompound: true,
+		Bytes:      bytes.Join(sorted, nil),
+	}
+}
+
+// newIssuerAndSerial returns the IssuerAndSerialNumber identifying cert.
+func newIssuerAndSerial(cert *x509.Certificate) pkcs7IssuerAndSerial {
+	return pkcs7IssuerAndSerial{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	}
+}
+
+// marshalContentInfo wraps the DER-encoded content in a ContentInfo of type
+// contentType.
+//
+// The content is explicitly tagged here, as encoding/asn1 ignores the tagging
+// parameters of pre-encoded RawValue fields.
+func marshalContentInfo(contentType asn1.ObjectIdentifier, content []byte) ([]byte, error) {
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: contentType,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      content,
+		},
+	})
+}
+
+// pkcs7SignatureAlgorithm returns the signature algorithm used when signing
+// with key, or an error if the key type is not supported.
+func pkcs7SignatureAlgorithm(key crypto.Signer) (pkcs7AlgorithmIdentifier, error) {
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		return pkcs7AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1Null}, nil
+	case *ecdsa.PublicKey:
+		return pkcs7AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, nil
+	default:
+		return pkcs7AlgorithmIdentifier{}, fmt.Errorf("mailyak: unsupported s/mime signing key type %T", key.Public())
+	}
+}
+
+// pkcs7RecipientKey returns the RSA public key of the recipient cert, or an
+// error if the key type is not supported.
+func pkcs7RecipientKey(cert *x509.Certificate) (*rsa.PublicKey, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("mailyak: unsupported s/mime recipient key type %T", cert.PublicKey)
+	}
+	return pub, nil
+}
+
+// pkcs7DetachedSignature returns a DER-encoded PKCS #7 SignedData structure
+// containing a detached SHA-256 signature of content, made with key and
+// identified by cert.
+//
+// cert and any intermediates are included in the SignedData.
+func pkcs7DetachedSignature(content []byte, cert *x509.Certificate, key crypto.Signer, intermediates []*x509.Certificate, signingTime time.Time) ([]byte, error) {
+	sigAlg, err := pkcs7SignatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := sha256.Sum256(content)
+	digestAlg := pkcs7AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1Null}
+
+	// Build the signed attributes.
+	var attrs [][]byte
+	for _, a := range []struct {
+		oid   asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidAttributeContentType, oidData},
+		{oidAttributeSigningTime, signingTime.UTC()},
+		{oidAttributeMessageDigest, digest[:]},
+	} {
+		v, err := asn1.Marshal(a.value)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(pkcs7Attribute{Type: a.oid, Values: derSet(v)})
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+
+	// The signature covers the DER encoding of the attributes as a SET OF,
+	// rather than the implicitly tagged form included in the SignerInfo.
+	attrSet, err := asn1.Marshal(derSet(attrs...))
+	if err != nil {
+		return nil, err
+	}
+	attrDigest := sha256.Sum256(attrSet)
+	signature, err := key.Sign(rand.Reader, attrDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	signerInfo, err := asn1.Marshal(pkcs7SignerInfo{
+		Version:            1,
+		SID:                newIssuerAndSerial(cert),
+		DigestAlgorithm:    digestAlg,
+		SignedAttrs:        derTagged(asn1.ClassContextSpecific, 0, attrs...),
+		SignatureAlgorithm: sigAlg,
+		Signature:          signature,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	digestAlgDER, err := asn1.Marshal(digestAlg)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := [][]byte{cert.Raw}
+	for _, c := range intermediates {
+		certs = append(certs, c.Raw)
+	}
+
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: derSet(digestAlgDER),
+		EncapContentInfo: pkcs7ContentInfo{ContentType: oidData},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      bytes.Join(certs, nil),
+		},
+		SignerInfos: derSet(signerInfo),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalContentInfo(oidSignedData, signedData)
+}
+
+// pkcs7Encrypt returns a DER-encoded PKCS #7 EnvelopedData structure
+// containing content encrypted with AES-256-CBC, with the content encryption
+// key encrypted to each of the RSA recipients.
+func pkcs7Encrypt(content []byte, recipients []*x509.Certificate) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("mailyak: no s/mime recipients")
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply PKCS #7 padding before encrypting.
+	padLen := aes.BlockSize - len(content)%aes.BlockSize
+	padded := make([]byte, len(content), len(content)+padLen)
+	copy(padded, content)
+	padded = append(padded, bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	var infos [][]byte
+	for _, cert := range recipients {
+		pub, err := pkcs7RecipientKey(cert)
+		if err != nil {
+			return nil, err
+		}
+
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := asn1.Marshal(pkcs7RecipientInfo{
+			Version:                0,
+			RID:                    newIssuerAndSerial(cert),
+			KeyEncryptionAlgorithm: pkcs7AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1Null},
+			EncryptedKey:           encryptedKey,
+		})
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	envelopedData, err := asn1.Marshal(pkcs7EnvelopedData{
+		Version:        0,
+		RecipientInfos: derSet(infos...),
+		EncryptedContentInfo: pkcs7EncryptedContentInfo{
+			ContentType: oidData,
+			ContentEncryptionAlgorithm: pkcs7AlgorithmIdentifier{
+				Algorithm:  oidAES256CBC,
+				Parameters: asn1.RawValue{FullBytes: ivDER},
+			},
+			EncryptedContent: asn1.RawValue{
+				Class: asn1.ClassContextSpecific,
+				Tag:   0,
+				Bytes: ciphertext,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalContentInfo(oidEnvelopedData, envelopedData)
+}
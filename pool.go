@@ -0,0 +1,397 @@
+package mailyak
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Default values used when the corresponding PoolConfig field is not set.
+const (
+	defaultPoolMaxConns    = 2
+	defaultPoolIdleTimeout = time.Minute
+)
+
+// poolHealthCheckAfter is how long a connection must be idle before it is
+// checked with NOOP when reused - connections used more recently than this
+// are assumed to be healthy, avoiding a round trip per email.
+const poolHealthCheckAfter = 5 * time.Second
+
+// ErrPoolClosed is returned when sending an email using a Pool that has been
+// closed.
+var ErrPoolClosed = errors.New("mailyak: pool is closed")
+
+// PoolConfig configures the behaviour of a Pool.
+type PoolConfig struct {
+	// MaxConns is the maximum number of concurrent connections to the SMTP
+	// server - sends in excess of this block until a connection becomes
+	// available, or their context is done.
+	//
+	// Defaults to 2 if zero.
+	MaxConns int
+
+	// IdleTimeout is the maximum amount of time a connection can remain idle
+	// before it is closed instead of being reused. Most SMTP servers close
+	// idle connections after a few minutes.
+	//
+	// Defaults to 1 minute if zero.
+	IdleTimeout time.Duration
+
+	// MaxMessagesPerConn is the maximum number of emails sent over a single
+	// connection before it is closed and a new one is opened. Some SMTP
+	// servers limit the number of emails accepted per connection.
+	//
+	// Unlimited if zero.
+	MaxMessagesPerConn int
+
+	// TLSPolicy controls whether connections opened by a Pool created with
+	// NewPool are upgraded with STARTTLS - see SetTLSPolicy for details.
+	//
+	// Ignored by NewPoolWithTLS, as the connections always use TLS. Defaults
+	// to TLSOpportunistic if zero.
+	TLSPolicy TLSPolicy
+
+	// StartTLSConfig is the tls.Config used to upgrade connections opened by
+	// a Pool created with NewPool - see SetStartTLSConfig for details.
+	//
+	// Ignored by NewPoolWithTLS, which accepts a tls.Config directly. A
+	// sensible default is used if nil.
+	StartTLSConfig *tls.Config
+}
+
+// poolConn is an established, authenticated connection to a SMTP server.
+type poolConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+	sent     int
+
+	// auth and localName are the credentials and EHLO name the connection
+	// was opened with.
+	auth      smtp.Auth
+	localName string
+}
+
+// Pool is a Sender that maintains a pool of authenticated connections to a
+// single SMTP server, reusing them to send multiple emails.
+//
+// Reusing connections avoids the overhead of the TCP/TLS connection setup,
+// EHLO, STARTTLS and AUTH for every email - a significant saving when sending
+// emails in bulk. Connections are reset with RSET between emails, and
+// connections idle for more than a few seconds are checked with NOOP before
+// being reused.
+//
+// Connections are authenticated with the credentials of the email that caused
+// them to be opened, and are only reused to send emails with the same
+// credentials and local name - an idle connection opened with different
+// credentials is closed to make room for a new connection.
+//
+// A Pool is safe for concurrent use, and must be closed with Close() once it
+// is no longer needed.
+type Pool struct {
+	hostAndPort string
+	hostname    string
+
+	// tlsConfig is nil for STARTTLS connections.
+	tlsConfig *tls.Config
+	config    PoolConfig
+
+	// startTLSConfig is used to upgrade STARTTLS connections according to the
+	// configured TLSPolicy, and is nil for explicit TLS connections.
+	startTLSConfig *tls.Config
+
+	// healthCheckAfter is how long a connection must be idle before it is
+	// checked with NOOP when reused, and is overridden in tests.
+	healthCheckAfter time.Duration
+
+	// sem bounds the number of open connections.
+	sem chan struct{}
+
+	mu     sync.Mutex
+	idle   []*poolConn
+	closed bool
+}
+
+// NewPool returns a Pool of connections to the SMTP server at host, upgraded
+// with STARTTLS according to the TLSPolicy in config.
+//
+// host must include the port number (i.e. "smtp.itsallbroken.com:25")
+//
+//	pool := mailyak.NewPool("smtp.itsallbroken.com:25", mailyak.PoolConfig{
+//	    MaxConns: 4,
+//	})
+//	defer pool.Close()
+//
+//	mail := mailyak.New("smtp.itsallbroken.com:25", auth)
+//	mail.UseSender(pool)
+func NewPool(host string, config PoolConfig) *Pool {
+	s := newSenderWithStartTLS(host)
+	s.setTLSConfig(config.StartTLSConfig)
+
+	p := newPool(s.hostAndPort, s.hostname, nil, config)
+	p.startTLSConfig = s.tlsConfig
+	return p
+}
+
+// NewPoolWithTLS returns a Pool of explicit TLS connections to the SMTP server
+// at host.
+//
+// If tlsConfig is nil, a sensible default is generated that can connect to
+// host.
+func NewPoolWithTLS(host string, tlsConfig *tls.Config, config PoolConfig) (*Pool, error) {
+	s, err := newSenderWithExplicitTLS(host, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPool(s.hostAndPort, s.hostname, s.tlsConfig, config), nil
+}
+
+func newPool(hostAndPort, hostname string, tlsConfig *tls.Config, config PoolConfig) *Pool {
+	if config.MaxConns <= 0 {
+		config.MaxConns = defaultPoolMaxConns
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultPoolIdleTimeout
+	}
+
+	return &Pool{
+		hostAndPort: hostAndPort,
+		hostname:    hostname,
+		tlsConfig:   tlsConfig,
+		config:      config,
+		sem:         make(chan struct{}, config.MaxConns),
+
+		healthCheckAfter: poolHealthCheckAfter,
+	}
+}
+
+// Send delivers m using a pooled connection, opening a new connection if no
+// idle connections are available and the MaxConns limit has not been reached.
+func (p *Pool) Send(ctx context.Context, m SendableMail) error {
+	// Wait for a connection slot.
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return newSMTPError(ctx, StageDial, ctx.Err())
+	}
+	defer func() { <-p.sem }()
+
+	env := m.Envelope()
+
+	pc, err := p.get(ctx, env)
+	if err != nil {
+		return err
+	}
+
+	stop := watchConn(ctx, pc.conn)
+	err = smtpTransaction(ctx, pc.client, env, m)
+	stop()
+
+	pc.sent++
+	p.put(ctx, pc, err)
+
+	return err
+}
+
+// get returns a healthy idle connection, or opens a new connection if none are
+// available.
+func (p *Pool) get(ctx context.Context, env Envelope) (*poolConn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		if len(p.idle) == 0 {
+			p.mu.Unlock()
+			break
+		}
+
+		// Use the most recently used connection opened for env.
+		i := len(p.idle) - 1
+		for i >= 0 && !p.idle[i].matches(env) {
+			i--
+		}
+		if i < 0 {
+			// Close the least recently used connection to stay within the
+			// MaxConns limit once a new connection is opened for env.
+			stale := p.idle[0]
+			p.idle = p.idle[1:]
+			p.mu.Unlock()
+
+			stale.quit()
+			break
+		}
+		pc := p.idle[i]
+		p.idle = append(p.idle[:i], p.idle[i+1:]...)
+		p.mu.Unlock()
+
+		idle := time.Since(pc.lastUsed)
+		if idle > p.config.IdleTimeout {
+			pc.quit()
+			continue
+		}
+		if idle < p.healthCheckAfter {
+			return pc, nil
+		}
+
+		// Check the connection is still usable - the server may have closed it
+		// while idle.
+		stop := watchConn(ctx, pc.conn)
+		err := pc.client.Noop()
+		stop()
+		if err != nil {
+			pc.close()
+			if ctx.Err() != nil {
+				return nil, newSMTPError(ctx, StageDial, err)
+			}
+			continue
+		}
+
+		return pc, nil
+	}
+
+	return p.dial(ctx, env)
+}
+
+// dial opens and authenticates a new connection.
+func (p *Pool) dial(ctx context.Context, env Envelope) (*poolConn, error) {
+	var pc *poolConn
+	err := retryTokenAuth(env.Auth, func() error {
+		var err error
+		pc, err = p.dialOnce(ctx, env)
+		return err
+	})
+	return pc, err
+}
+
+// dialOnce opens and authenticates a new connection, without retrying.
+func (p *Pool) dialOnce(ctx context.Context, env Envelope) (*poolConn, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if p.tlsConfig != nil {
+		conn, err = dialTLS(ctx, p.hostAndPort, p.tlsConfig)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", p.hostAndPort)
+	}
+	if err != nil {
+		return nil, newSMTPError(ctx, StageDial, err)
+	}
+
+	stop := watchConn(ctx, conn)
+	defer stop()
+
+	c, err := smtp.NewClient(conn, p.hostname)
+	if err != nil {
+		_ = conn.Close()
+		return nil, newSMTPError(ctx, StageHello, err)
+	}
+
+	tlsPolicy := p.config.TLSPolicy
+	if p.tlsConfig != nil {
+		// Explicit TLS connections are not upgraded.
+		tlsPolicy = TLSDisabled
+	}
+
+	if err := smtpHandshake(ctx, c, env, tlsPolicy, p.startTLSConfig); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+
+	return &poolConn{
+		conn:      conn,
+		client:    c,
+		auth:      env.Auth,
+		localName: env.LocalName,
+	}, nil
+}
+
+// put returns pc to the idle pool if it can be reused after a send that
+// returned sendErr, otherwise pc is closed.
+func (p *Pool) put(ctx context.Context, pc *poolConn, sendErr error) {
+	// Connections that were interrupted by ctx, or that failed for a reason
+	// other than the server rejecting a command are not reusable.
+	var (
+		protoErr   *textproto.Error
+		partialErr *PartialDeliveryError
+	)
+	if ctx.Err() != nil || (sendErr != nil && !errors.As(sendErr, &protoErr) && !errors.As(sendErr, &partialErr)) {
+		pc.close()
+		return
+	}
+
+	if p.config.MaxMessagesPerConn > 0 && pc.sent >= p.config.MaxMessagesPerConn {
+		pc.quit()
+		return
+	}
+
+	// Reset the connection state ready for the next email.
+	stop := watchConn(ctx, pc.conn)
+	err := pc.client.Reset()
+	stop()
+	if err != nil {
+		pc.close()
+		return
+	}
+
+	pc.lastUsed = time.Now()
+
+	p.mu.Lock()
+	closed := p.closed
+	if !closed {
+		p.idle = append(p.idle, pc)
+	}
+	p.mu.Unlock()
+
+	if closed {
+		pc.quit()
+	}
+}
+
+// Close closes all idle connections, and prevents further emails being sent
+// using p. Connections in use are closed once their send completes.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, pc := range idle {
+		pc.quit()
+	}
+
+	return nil
+}
+
+// matches returns true if pc was opened with the credentials and local name of
+// env, and can be used to send it.
+func (pc *poolConn) matches(env Envelope) bool {
+	// Auth values are compared by value, as a new smtp.Auth is often created
+	// for each email.
+	return pc.localName == env.LocalName && reflect.DeepEqual(pc.auth, env.Auth)
+}
+
+// quit gracefully closes the connection.
+func (pc *poolConn) quit() {
+	// Bound the time spent waiting for the server to acknowledge the QUIT.
+	_ = pc.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := pc.client.Quit(); err != nil {
+		pc.close()
+	}
+}
+
+// close immediately closes the connection.
+func (pc *poolConn) close() {
+	_ = pc.client.Close()
+}
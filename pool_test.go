@@ -0,0 +1,576 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/smtp"
+	"testing"
+	"time"
+)
+
+// poolTransaction asserts the client sends an email to the given recipient,
+// without resetting the connection afterwards.
+func poolTransaction(c *connAsserts, to string) {
+	c.Expect("MAIL FROM:<from@example.org>\r\n")
+	c.Respond("250 OK\r\n")
+
+	c.Expect("RCPT TO:<" + to + ">\r\n")
+	c.Respond("250 OK\r\n")
+
+	c.Expect("DATA\r\n")
+	c.Respond("354 OK\r\n")
+	c.Expect("bananas\r\n.\r\n")
+	c.Respond("250 Will do friend\r\n")
+}
+
+// servePoolConns accepts a connection on socket for each handler in turn,
+// sending the greeting and EHLO reply before calling the handler with the
+// connection.
+//
+// The returned channel is closed once all handlers have returned.
+func servePoolConns(t *testing.T, socket net.Listener, handlers ...func(c *connAsserts)) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		for _, fn := range handlers {
+			conn, err := socket.Accept()
+			if err != nil {
+				panic(err)
+			}
+
+			c := newConnAsserts(conn, t)
+			c.Respond("220 localhost ESMTP bananas\r\n")
+			c.Expect("EHLO localhost\r\n")
+			c.Respond("250 localhost Hola\r\n")
+
+			fn(c)
+			conn.Close()
+		}
+	}()
+
+	return done
+}
+
+// poolMail returns a mockMail addressed to to, for sending with a Pool.
+func poolMail(to string) *mockMail {
+	return &mockMail{
+		toAddrs:  []string{to},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+	}
+}
+
+// expectClosed asserts the client closes the connection without sending any
+// further commands.
+func expectClosed(c *connAsserts) {
+	if n, err := c.Conn.Read(make([]byte, 1)); err == nil {
+		c.t.Errorf("expected connection to be closed, read %d bytes", n)
+	}
+}
+
+// waitPoolConns waits for done to be closed, failing the test if it takes too
+// long.
+func waitPoolConns(t *testing.T, done <-chan struct{}) {
+	t.Helper()
+
+	select {
+	case <-done:
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for SMTP conversation to complete")
+	}
+}
+
+// TestPoolReusesConnections ensures multiple emails sent using a Pool reuse a
+// single authenticated connection, resetting it between emails.
+func TestPoolReusesConnections(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	transaction := func(c *connAsserts, to string) {
+		poolTransaction(c, to)
+
+		c.Expect("RSET\r\n")
+		c.Respond("250 OK\r\n")
+	}
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+
+		conn, err := socket.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		c := newConnAsserts(conn, t)
+		c.Respond("220 localhost ESMTP bananas\r\n")
+
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250-localhost Hola\r\n")
+		c.Respond("250 AUTH LOGIN PLAIN\r\n")
+
+		c.Expect("AUTH PLAIN aWRlbnQAdXNlcgBwYXNz\r\n")
+		c.Respond("235 Looks good\r\n")
+
+		transaction(c, "one@example.org")
+
+		// The connection was used moments ago, so is reused without a
+		// NOOP health check.
+		transaction(c, "two@example.org")
+
+		c.Expect("QUIT\r\n")
+		c.Respond("221 Adios\r\n")
+	}()
+
+	pool := NewPool(socket.Addr().String(), PoolConfig{MaxConns: 1})
+
+	for _, to := range []string{"one@example.org", "two@example.org"} {
+		err := pool.Send(context.Background(), &mockMail{
+			toAddrs:  []string{to},
+			fromAddr: "from@example.org",
+			mime:     "bananas",
+			auth:     smtp.PlainAuth("ident", "user", "pass", "127.0.0.1"),
+		})
+		if err != nil {
+			t.Fatalf("send to %s: %v", to, err)
+		}
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-handlerDone:
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for SMTP conversation to complete")
+	}
+
+	// Sending after the pool is closed must fail.
+	err = pool.Send(context.Background(), &mockMail{})
+	if !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("got %v, want %v", err, ErrPoolClosed)
+	}
+}
+
+// TestPoolAuthMismatch ensures idle connections are not reused to send emails
+// with different credentials, and are closed to make room for a connection
+// authenticated with the new credentials.
+func TestPoolAuthMismatch(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+
+		for _, tt := range []struct {
+			auth string
+			to   string
+		}{
+			{"AGFsaWNlAHBhc3M=", "one@example.org"},
+			{"AGJvYgBwYXNz", "two@example.org"},
+		} {
+			conn, err := socket.Accept()
+			if err != nil {
+				panic(err)
+			}
+
+			c := newConnAsserts(conn, t)
+			c.Respond("220 localhost ESMTP bananas\r\n")
+
+			c.Expect("EHLO localhost\r\n")
+			c.Respond("250-localhost Hola\r\n")
+			c.Respond("250 AUTH LOGIN PLAIN\r\n")
+
+			c.Expect("AUTH PLAIN " + tt.auth + "\r\n")
+			c.Respond("235 Looks good\r\n")
+
+			poolTransaction(c, tt.to)
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+
+			// Closed by the next send, or closing the pool.
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+			conn.Close()
+		}
+	}()
+
+	pool := NewPool(socket.Addr().String(), PoolConfig{MaxConns: 1})
+
+	for _, tt := range []struct {
+		user string
+		to   string
+	}{
+		{"alice", "one@example.org"},
+		{"bob", "two@example.org"},
+	} {
+		err := pool.Send(context.Background(), &mockMail{
+			toAddrs:  []string{tt.to},
+			fromAddr: "from@example.org",
+			mime:     "bananas",
+			auth:     smtp.PlainAuth("", tt.user, "pass", "127.0.0.1"),
+		})
+		if err != nil {
+			t.Fatalf("send to %s: %v", tt.to, err)
+		}
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitPoolConns(t, handlerDone)
+}
+
+// TestPoolMaxConns ensures sends block when the pool has MaxConns connections
+// in use, and respect the context deadline while waiting.
+func TestPoolMaxConns(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	accepted := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+	go func() {
+		for {
+			conn, err := socket.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+
+			// Stall the connection without sending a greeting until the test
+			// completes.
+			go func() {
+				defer conn.Close()
+				<-release
+			}()
+		}
+	}()
+
+	pool := NewPool(socket.Addr().String(), PoolConfig{MaxConns: 1})
+	defer pool.Close()
+
+	// Occupy the only connection slot.
+	go func() {
+		_ = pool.Send(context.Background(), &mockMail{})
+	}()
+	<-accepted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = pool.Send(ctx, &mockMail{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) || smtpErr.Stage != StageDial {
+		t.Fatalf("got %v, want *SMTPError at stage %q", err, StageDial)
+	}
+
+	select {
+	case <-accepted:
+		t.Fatal("pool opened more than MaxConns connections")
+	default:
+	}
+}
+
+// TestPoolTLSRequired ensures a Pool using the TLSRequired policy refuses to
+// use a connection that cannot be upgraded with STARTTLS.
+func TestPoolTLSRequired(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+
+		conn, err := socket.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		c := newConnAsserts(conn, t)
+		c.Respond("220 localhost ESMTP bananas\r\n")
+
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250-localhost Hola\r\n")
+		c.Respond("250 AUTH LOGIN PLAIN\r\n")
+
+		// The connection is closed without sending the credentials.
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("expected connection to be closed")
+		}
+	}()
+
+	pool := NewPool(socket.Addr().String(), PoolConfig{TLSPolicy: TLSRequired})
+	defer pool.Close()
+
+	err = pool.Send(context.Background(), &mockMail{
+		toAddrs:  []string{"to@example.org"},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+		auth:     smtp.PlainAuth("ident", "user", "pass", "127.0.0.1"),
+	})
+	if !errors.Is(err, ErrStartTLSNotSupported) {
+		t.Fatalf("got %v, want %v", err, ErrStartTLSNotSupported)
+	}
+
+	select {
+	case <-handlerDone:
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for SMTP conversation to complete")
+	}
+}
+
+// TestPoolHealthCheck ensures connections idle for longer than the health
+// check threshold are checked with NOOP before reuse, and replaced with a new
+// connection if the check fails.
+func TestPoolHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	done := servePoolConns(t, socket,
+		func(c *connAsserts) {
+			poolTransaction(c, "one@example.org")
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+
+			c.Expect("NOOP\r\n")
+			c.Respond("250 OK\r\n")
+
+			poolTransaction(c, "two@example.org")
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+
+			// The server closes the connection while it is idle.
+			c.Expect("NOOP\r\n")
+			c.Respond("421 Timeout\r\n")
+		},
+		// The failed health check causes a new connection to be opened.
+		func(c *connAsserts) {
+			poolTransaction(c, "three@example.org")
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+	)
+
+	pool := NewPool(socket.Addr().String(), PoolConfig{MaxConns: 1})
+	pool.healthCheckAfter = 0
+
+	for _, to := range []string{"one@example.org", "two@example.org", "three@example.org"} {
+		if err := pool.Send(context.Background(), poolMail(to)); err != nil {
+			t.Fatalf("send to %s: %v", to, err)
+		}
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitPoolConns(t, done)
+}
+
+// TestPoolIdleTimeout ensures connections idle for longer than IdleTimeout are
+// closed instead of being reused.
+func TestPoolIdleTimeout(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	done := servePoolConns(t, socket,
+		func(c *connAsserts) {
+			poolTransaction(c, "one@example.org")
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+
+			// The expired connection is closed gracefully.
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+		func(c *connAsserts) {
+			poolTransaction(c, "two@example.org")
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+	)
+
+	pool := NewPool(socket.Addr().String(), PoolConfig{
+		MaxConns:    1,
+		IdleTimeout: 50 * time.Millisecond,
+	})
+
+	if err := pool.Send(context.Background(), poolMail("one@example.org")); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := pool.Send(context.Background(), poolMail("two@example.org")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitPoolConns(t, done)
+}
+
+// TestPoolMaxMessagesPerConn ensures connections are closed and replaced once
+// MaxMessagesPerConn emails have been sent over them.
+func TestPoolMaxMessagesPerConn(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	// Each connection sends two emails before it is closed, without a reset.
+	handler := func(first, second string) func(c *connAsserts) {
+		return func(c *connAsserts) {
+			poolTransaction(c, first)
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+
+			poolTransaction(c, second)
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		}
+	}
+
+	done := servePoolConns(t, socket,
+		handler("one@example.org", "two@example.org"),
+		handler("three@example.org", "four@example.org"),
+	)
+
+	pool := NewPool(socket.Addr().String(), PoolConfig{
+		MaxConns:           1,
+		MaxMessagesPerConn: 2,
+	})
+	defer pool.Close()
+
+	for _, to := range []string{"one@example.org", "two@example.org", "three@example.org", "four@example.org"} {
+		if err := pool.Send(context.Background(), poolMail(to)); err != nil {
+			t.Fatalf("send to %s: %v", to, err)
+		}
+	}
+
+	waitPoolConns(t, done)
+}
+
+// failingMail is a SendableMail that fails to write its MIME content.
+type failingMail struct {
+	mockMail
+	err error
+}
+
+func (m *failingMail) WriteMime(w io.Writer) error {
+	return m.err
+}
+
+// TestPoolClosesFailedConnections ensures a connection that fails for a reason
+// other than a server reply is closed instead of being reused, while a
+// connection that received a rejection reply is reset and reused.
+func TestPoolClosesFailedConnections(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	done := servePoolConns(t, socket,
+		func(c *connAsserts) {
+			// A rejected recipient leaves the connection usable.
+			c.Expect("MAIL FROM:<from@example.org>\r\n")
+			c.Respond("250 OK\r\n")
+			c.Expect("RCPT TO:<rejected@example.org>\r\n")
+			c.Respond("550 5.1.1 No such user\r\n")
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+
+			// The email content cannot be generated, leaving the DATA
+			// command incomplete.
+			c.Expect("MAIL FROM:<from@example.org>\r\n")
+			c.Respond("250 OK\r\n")
+			c.Expect("RCPT TO:<to@example.org>\r\n")
+			c.Respond("250 OK\r\n")
+			c.Expect("DATA\r\n")
+			c.Respond("354 OK\r\n")
+
+			expectClosed(c)
+		},
+		func(c *connAsserts) {
+			poolTransaction(c, "to@example.org")
+			c.Expect("RSET\r\n")
+			c.Respond("250 OK\r\n")
+			c.Expect("QUIT\r\n")
+			c.Respond("221 Adios\r\n")
+		},
+	)
+
+	pool := NewPool(socket.Addr().String(), PoolConfig{MaxConns: 1})
+
+	var smtpErr *SMTPError
+	err = pool.Send(context.Background(), poolMail("rejected@example.org"))
+	if !errors.As(err, &smtpErr) || smtpErr.Code != 550 {
+		t.Fatalf("got %v, want 550 rejection", err)
+	}
+
+	wantErr := errors.New("broken attachment")
+	err = pool.Send(context.Background(), &failingMail{mockMail: *poolMail("to@example.org"), err: wantErr})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("got %v, want %v", err, wantErr)
+	}
+
+	if err := pool.Send(context.Background(), poolMail("to@example.org")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pool.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitPoolConns(t, done)
+}
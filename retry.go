@@ -0,0 +1,276 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+)
+
+// Default values used when the corresponding RetryPolicy field is not set.
+const (
+	defaultRetryMaxAttempts    = 3
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryMultiplier     = 2
+)
+
+// RetryPolicy configures how temporary send failures are retried.
+//
+// The delay before retry n (starting from 1) is:
+//
+//	InitialBackoff * Multiplier^(n-1)
+//
+// capped at MaxBackoff, and then randomised by up to ±Jitter of the delay.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of send attempts made to each host,
+	// including the first.
+	//
+	// Defaults to 3 if zero.
+	MaxAttempts int
+
+	// InitialBackoff is the delay before the first retry.
+	//
+	// Defaults to 1 second if zero.
+	InitialBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between retries.
+	//
+	// Defaults to 30 seconds if zero.
+	MaxBackoff time.Duration
+
+	// Multiplier is the factor the delay is increased by after each retry.
+	//
+	// Defaults to 2 if zero.
+	Multiplier float64
+
+	// Jitter is the fraction of the delay (between 0 and 1) by which it is
+	// randomly increased or decreased, preventing many clients retrying in
+	// lockstep.
+	//
+	// No jitter is applied if zero.
+	Jitter float64
+}
+
+// withDefaults returns a copy of p with unset fields initialised to their
+// default values.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultRetryInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if p.Multiplier <= 0 {
+		p.Multiplier = defaultRetryMultiplier
+	}
+	return p
+}
+
+// backoff returns the delay before retry n (starting from 1), using random as
+// the source of jitter.
+func (p RetryPolicy) backoff(n int, random func() float64) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(n-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*random() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+// Attempt describes a single failed attempt to send an email.
+type Attempt struct {
+	// Host is the SMTP server the attempt was made to.
+	Host string
+
+	// Number is the attempt number for Host, starting from 1.
+	Number int
+
+	// Err is the error returned by the attempt.
+	Err error
+}
+
+// RetryError is returned when an email cannot be sent after retrying, and
+// describes every attempt made.
+type RetryError struct {
+	// Attempts lists every failed attempt, in the order they were made.
+	Attempts []Attempt
+
+	// Err is the error that caused retrying to stop - either the error
+	// returned by the last attempt, or the context error if the context was
+	// done while waiting to retry.
+	Err error
+}
+
+func (e *RetryError) Error() string {
+	attempts := make([]string, 0, len(e.Attempts))
+	for _, a := range e.Attempts {
+		attempts = append(attempts, fmt.Sprintf("%s (attempt %d): %v", a.Host, a.Number, a.Err))
+	}
+
+	return fmt.Sprintf(
+		"mailyak: send failed after %d attempts: %v [%s]",
+		len(e.Attempts),
+		e.Err,
+		strings.Join(attempts, "; "),
+	)
+}
+
+// Unwrap returns the error that caused retrying to stop.
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// relay is a SMTP server and the Sender used to deliver email to it.
+type relay struct {
+	host   string
+	sender Sender
+}
+
+// hostSender is implemented by the SMTP senders, allowing a copy to be
+// created that connects to a different host.
+type hostSender interface {
+	withHost(hostAndPort string) (Sender, error)
+}
+
+// retrySender wraps a set of Sender implementations, retrying temporary
+// failures and failing over to the next relay in order.
+type retrySender struct {
+	relays []relay
+	policy RetryPolicy
+
+	// random returns a random float in [0, 1) used as the source of jitter.
+	random func() float64
+}
+
+// SetRetryPolicy configures m to retry temporary send failures according to
+// policy, optionally failing over to an ordered list of alternate SMTP relays.
+//
+// Sends are retried if the server replies with a temporary (4xx) reply code,
+// or if the connection fails (such as a connection reset). Permanent (5xx)
+// failures, authentication/TLS errors and partial deliveries are not retried.
+//
+// Each host is tried up to MaxAttempts times before failing over to the next
+// host in failoverHosts, using the same TLS configuration as m:
+//
+//	err := mail.SetRetryPolicy(mailyak.RetryPolicy{
+//	    MaxAttempts:    3,
+//	    InitialBackoff: time.Second,
+//	    Jitter:         0.2,
+//	}, "backup-relay.itsallbroken.com:25")
+//
+// If every attempt fails, Send() returns a *RetryError describing each attempt.
+// Use SendContext to bound the total time spent retrying.
+//
+// Failover hosts are only supported when using the SMTP senders configured by
+// New() and NewWithTLS() - if a custom Sender is set with UseSender() then
+// only the retry policy is applied.
+func (m *MailYak) SetRetryPolicy(policy RetryPolicy, failoverHosts ...string) error {
+	primary := m.sender
+	if rs, ok := primary.(*retrySender); ok {
+		primary = rs.relays[0].sender
+	}
+
+	relays := []relay{{host: m.host, sender: primary}}
+	for _, host := range failoverHosts {
+		s, ok := primary.(hostSender)
+		if !ok {
+			return errors.New("mailyak: failover hosts are not supported by the configured sender")
+		}
+
+		failover, err := s.withHost(host)
+		if err != nil {
+			return err
+		}
+
+		relays = append(relays, relay{host: host, sender: failover})
+	}
+
+	m.sender = &retrySender{
+		relays: relays,
+		policy: policy.withDefaults(),
+		random: rand.Float64,
+	}
+
+	return nil
+}
+
+// Send attempts to deliver m using each relay in turn, retrying temporary
+// failures according to the retry policy.
+func (s *retrySender) Send(ctx context.Context, m SendableMail) error {
+	retryErr := &RetryError{}
+
+	for _, r := range s.relays {
+		for n := 1; n <= s.policy.MaxAttempts; n++ {
+			if n > 1 {
+				if err := sleepContext(ctx, s.policy.backoff(n-1, s.random)); err != nil {
+					retryErr.Err = err
+					return retryErr
+				}
+			}
+
+			err := r.sender.Send(ctx, m)
+			if err == nil {
+				return nil
+			}
+
+			retryErr.Attempts = append(retryErr.Attempts, Attempt{
+				Host:   r.host,
+				Number: n,
+				Err:    err,
+			})
+			retryErr.Err = err
+
+			if ctx.Err() != nil || !isRetryable(err) {
+				return retryErr
+			}
+		}
+	}
+
+	return retryErr
+}
+
+// isRetryable returns true if err is a temporary failure that may succeed if
+// retried.
+func isRetryable(err error) bool {
+	var smtpErr *SMTPError
+	if !errors.As(err, &smtpErr) {
+		return false
+	}
+
+	if smtpErr.Code != 0 {
+		return smtpErr.Temporary()
+	}
+
+	// Without a reply code, only connection failures are retried.
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// sleepContext blocks for d, or until ctx is done, returning the ctx error in
+// the latter case.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,284 @@
+package mailyak
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// scriptedSender is a Sender that returns each error in errs in turn, and nil
+// once exhausted.
+type scriptedSender struct {
+	errs  []error
+	calls int
+}
+
+func (s *scriptedSender) Send(ctx context.Context, m SendableMail) error {
+	s.calls++
+	if len(s.errs) == 0 {
+		return nil
+	}
+
+	err := s.errs[0]
+	s.errs = s.errs[1:]
+	return err
+}
+
+// TestRetrySender ensures temporary failures are retried, and the relays are
+// tried in order.
+func TestRetrySender(t *testing.T) {
+	t.Parallel()
+
+	var (
+		temporary = &SMTPError{Stage: StageMail, Code: 451, Err: &textproto.Error{Code: 451, Msg: "Try later"}}
+		permanent = &SMTPError{Stage: StageRcpt, Code: 550, Err: &textproto.Error{Code: 550, Msg: "No"}}
+		reset     = &SMTPError{Stage: StageData, Err: io.ErrUnexpectedEOF}
+		auth      = &SMTPError{Stage: StageAuth, Err: errors.New("unencrypted connection")}
+	)
+
+	tests := []struct {
+		name    string
+		primary []error
+		backup  []error
+
+		wantPrimaryCalls int
+		wantBackupCalls  int
+		wantAttempts     []Attempt
+	}{
+		{
+			name:             "ok",
+			wantPrimaryCalls: 1,
+		},
+		{
+			name:             "retry temporary",
+			primary:          []error{temporary, reset},
+			wantPrimaryCalls: 3,
+		},
+		{
+			name:             "permanent not retried",
+			primary:          []error{permanent},
+			wantPrimaryCalls: 1,
+			wantAttempts: []Attempt{
+				{Host: "primary:25", Number: 1, Err: permanent},
+			},
+		},
+		{
+			name:             "auth not retried",
+			primary:          []error{auth},
+			wantPrimaryCalls: 1,
+			wantAttempts: []Attempt{
+				{Host: "primary:25", Number: 1, Err: auth},
+			},
+		},
+		{
+			name:             "failover",
+			primary:          []error{temporary, temporary, reset},
+			wantPrimaryCalls: 3,
+			wantBackupCalls:  1,
+		},
+		{
+			name:             "all failed",
+			primary:          []error{temporary, temporary, temporary},
+			backup:           []error{reset, temporary, temporary},
+			wantPrimaryCalls: 3,
+			wantBackupCalls:  3,
+			wantAttempts: []Attempt{
+				{Host: "primary:25", Number: 1, Err: temporary},
+				{Host: "primary:25", Number: 2, Err: temporary},
+				{Host: "primary:25", Number: 3, Err: temporary},
+				{Host: "backup:25", Number: 1, Err: reset},
+				{Host: "backup:25", Number: 2, Err: temporary},
+				{Host: "backup:25", Number: 3, Err: temporary},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				primary = &scriptedSender{errs: tt.primary}
+				backup  = &scriptedSender{errs: tt.backup}
+			)
+
+			s := &retrySender{
+				relays: []relay{
+					{host: "primary:25", sender: primary},
+					{host: "backup:25", sender: backup},
+				},
+				policy: RetryPolicy{
+					MaxAttempts:    3,
+					InitialBackoff: time.Millisecond,
+				}.withDefaults(),
+				random: func() float64 { return 0.5 },
+			}
+
+			err := s.Send(context.Background(), &mockMail{})
+
+			if primary.calls != tt.wantPrimaryCalls {
+				t.Errorf("got %d primary calls, want %d", primary.calls, tt.wantPrimaryCalls)
+			}
+			if backup.calls != tt.wantBackupCalls {
+				t.Errorf("got %d backup calls, want %d", backup.calls, tt.wantBackupCalls)
+			}
+
+			if tt.wantAttempts == nil {
+				if err != nil {
+					t.Fatalf("got %v, want nil", err)
+				}
+				return
+			}
+
+			var retryErr *RetryError
+			if !errors.As(err, &retryErr) {
+				t.Fatalf("got %T, want *RetryError", err)
+			}
+			if !reflect.DeepEqual(retryErr.Attempts, tt.wantAttempts) {
+				t.Errorf("got attempts %+v, want %+v", retryErr.Attempts, tt.wantAttempts)
+			}
+
+			last := tt.wantAttempts[len(tt.wantAttempts)-1].Err
+			if !errors.Is(err, last) {
+				t.Errorf("errors.Is(%v, %v) = false", err, last)
+			}
+		})
+	}
+}
+
+// TestRetrySender_contextDone ensures retrying stops once the context is done.
+func TestRetrySender_contextDone(t *testing.T) {
+	t.Parallel()
+
+	temporary := &SMTPError{Stage: StageMail, Code: 421}
+
+	s := &retrySender{
+		relays: []relay{{host: "primary:25", sender: &scriptedSender{errs: []error{temporary, temporary}}}},
+		policy: RetryPolicy{InitialBackoff: time.Hour}.withDefaults(),
+		random: func() float64 { return 0.5 },
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := s.Send(ctx, &mockMail{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) || len(retryErr.Attempts) != 1 {
+		t.Fatalf("got %v, want a *RetryError with 1 attempt", err)
+	}
+}
+
+// TestRetryPolicyBackoff ensures the backoff delays grow exponentially, are
+// capped and have jitter applied.
+func TestRetryPolicyBackoff(t *testing.T) {
+	t.Parallel()
+
+	p := RetryPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, w := range want {
+		if got := p.backoff(i+1, nil); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	if got := p.backoff(1, func() float64 { return 0 }); got != 500*time.Millisecond {
+		t.Errorf("backoff with minimum jitter = %v, want %v", got, 500*time.Millisecond)
+	}
+	if got := p.backoff(1, func() float64 { return 1 }); got != 1500*time.Millisecond {
+		t.Errorf("backoff with maximum jitter = %v, want %v", got, 1500*time.Millisecond)
+	}
+}
+
+// TestMailYakSetRetryPolicy ensures a MailYak with a retry policy fails over to
+// the next host when the primary host is unavailable.
+func TestMailYakSetRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	// Bind and immediately close a socket to obtain an address that refuses
+	// connections.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+
+		conn, err := socket.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		c := newConnAsserts(conn, t)
+		c.Respond("220 localhost ESMTP bananas\r\n")
+
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250 localhost Hola\r\n")
+
+		c.Expect("MAIL FROM:<from@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+
+		c.Expect("RCPT TO:<to@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+
+		c.Expect("DATA\r\n")
+		c.Respond("354 OK\r\n")
+		c.Expect("bananas\r\n.\r\n")
+		c.Respond("250 Will do friend\r\n")
+
+		c.Expect("QUIT\r\n")
+		c.Respond("221 Adios\r\n")
+	}()
+
+	m := New(closedAddr, nil)
+	err = m.SetRetryPolicy(RetryPolicy{
+		MaxAttempts:    2,
+		InitialBackoff: time.Millisecond,
+	}, socket.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.sender.Send(context.Background(), &mockMail{
+		toAddrs:  []string{"to@example.org"},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-handlerDone
+
+	// Failover hosts require an SMTP sender.
+	m.UseSender(&scriptedSender{})
+	if err := m.SetRetryPolicy(RetryPolicy{}, "backup:25"); err == nil {
+		t.Fatal("expected error setting failover hosts for a custom sender")
+	}
+}
@@ -0,0 +1,252 @@
+package mailyak
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec // SCRAM-SHA-1 is defined using SHA-1
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/smtp"
+	"strconv"
+	"strings"
+)
+
+// scramAuth implements the SCRAM-SHA-1 and SCRAM-SHA-256 authentication
+// mechanisms (RFC 5802 and RFC 7677).
+//
+// A scramAuth holds the state of a single exchange, so must not be used by
+// more than one connection at a time. MailYak and Pool start each exchange with
+// a new scramAuth from newExchange, so the smtp.Auth returned by ScramSHA1Auth
+// and ScramSHA256Auth can be shared between connections.
+type scramAuth struct {
+	mechanism string
+	hash      func() hash.Hash
+	username  string
+	password  string
+
+	// nonce generates the client nonce, and is overridden in tests.
+	nonce func() (string, error)
+
+	// clientNonce and bare are the client nonce and
+	// client-first-message-bare sent by Start, awaiting the server's first
+	// challenge.
+	clientNonce string
+	bare        string
+
+	// serverSignature is the expected server signature, awaiting the
+	// server's final challenge.
+	serverSignature string
+
+	// verified is true when the server signature has been verified and the
+	// exchange is awaiting the success reply.
+	verified bool
+}
+
+// ScramSHA1Auth returns an smtp.Auth that implements the SCRAM-SHA-1
+// authentication mechanism (RFC 5802), authenticating as username.
+//
+// SCRAM proves knowledge of the password without sending it to the server, and
+// verifies the server also knows the password, so the credentials cannot be
+// captured and reused by an eavesdropper or a server impersonating the real
+// one. Prefer ScramSHA256Auth where supported by the server.
+//
+// The password must not require SASLprep normalisation (RFC 4013) - ASCII
+// passwords are always suitable.
+//
+// The returned smtp.Auth can be shared by any number of MailYak emails and
+// Pool connections. If used directly with net/smtp, it must not be used by more
+// than one connection at a time.
+func ScramSHA1Auth(username, password string) smtp.Auth {
+	return newSCRAMAuth("SCRAM-SHA-1", sha1.New, username, password)
+}
+
+// ScramSHA256Auth returns an smtp.Auth that implements the SCRAM-SHA-256
+// authentication mechanism (RFC 7677), authenticating as username:
+//
+//	mail := mailyak.New("mail.host.com:25", mailyak.ScramSHA256Auth("user", "pass"))
+//
+// The password is never sent to the server, as described in ScramSHA1Auth.
+func ScramSHA256Auth(username, password string) smtp.Auth {
+	return newSCRAMAuth("SCRAM-SHA-256", sha256.New, username, password)
+}
+
+func newSCRAMAuth(mechanism string, h func() hash.Hash, username, password string) *scramAuth {
+	return &scramAuth{
+		mechanism: mechanism,
+		hash:      h,
+		username:  username,
+		password:  password,
+		nonce:     randomNonce,
+	}
+}
+
+// newExchange returns a new scramAuth with the same credentials, holding the
+// state of a single exchange.
+func (a *scramAuth) newExchange() smtp.Auth {
+	return &scramAuth{
+		mechanism: a.mechanism,
+		hash:      a.hash,
+		username:  a.username,
+		password:  a.password,
+		nonce:     a.nonce,
+	}
+}
+
+// randomNonce returns a random SCRAM client nonce.
+func randomNonce() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(buf), nil
+}
+
+func (a *scramAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	nonce, err := a.nonce()
+	if err != nil {
+		return "", nil, err
+	}
+
+	bare := "n=" + gs2Name(a.username) + ",r=" + nonce
+
+	// Start a new exchange, discarding the state of any previous exchange.
+	a.clientNonce, a.bare = nonce, bare
+	a.serverSignature, a.verified = "", false
+
+	// No channel binding, and no authorization identity.
+	return a.mechanism, []byte("n,," + bare), nil
+}
+
+func (a *scramAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	msg := string(fromServer)
+
+	if !more {
+		// The exchange must have verified the server signature before the
+		// server reports success.
+		if !a.verified {
+			return nil, errors.New("mailyak: scram server signature not received")
+		}
+		a.verified = false
+		return nil, nil
+	}
+
+	switch {
+	case strings.HasPrefix(msg, "r="):
+		return a.clientFinal(msg)
+	case strings.HasPrefix(msg, "v="):
+		return a.verifyServer(msg)
+	case strings.HasPrefix(msg, "e="):
+		return nil, fmt.Errorf("mailyak: scram authentication failed: %s", msg[2:])
+	}
+
+	return nil, fmt.Errorf("mailyak: invalid scram challenge %q", msg)
+}
+
+// clientFinal returns the client-final-message in response to the
+// server-first-message msg.
+func (a *scramAuth) clientFinal(msg string) ([]byte, error) {
+	var (
+		nonce, salt string
+		iterations  int
+	)
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			return nil, fmt.Errorf("mailyak: invalid scram challenge %q", msg)
+		}
+		switch attr[0] {
+		case 'r':
+			nonce = attr[2:]
+		case 's':
+			salt = attr[2:]
+		case 'i':
+			var err error
+			if iterations, err = strconv.Atoi(attr[2:]); err != nil {
+				return nil, fmt.Errorf("mailyak: invalid scram iteration count: %w", err)
+			}
+		case 'm':
+			return nil, errors.New("mailyak: unsupported scram extension")
+		}
+	}
+
+	saltBytes, err := base64.StdEncoding.DecodeString(salt)
+	if err != nil || len(saltBytes) == 0 {
+		return nil, fmt.Errorf("mailyak: invalid scram salt %q", salt)
+	}
+	if iterations < 1 {
+		return nil, fmt.Errorf("mailyak: invalid scram iteration count %d", iterations)
+	}
+
+	// The server nonce must extend the client nonce sent by Start.
+	bare := a.bare
+	if bare == "" || len(nonce) <= len(a.clientNonce) || !strings.HasPrefix(nonce, a.clientNonce) {
+		return nil, errors.New("mailyak: scram server nonce does not match client nonce")
+	}
+	a.clientNonce, a.bare = "", ""
+
+	salted := pbkdf2(a.hash, []byte(a.password), saltBytes, iterations)
+	clientKey := hmacSum(a.hash, salted, []byte("Client Key"))
+	storedKey := a.hash()
+	storedKey.Write(clientKey)
+
+	// "biws" is the base64 encoded GS2 header "n,,".
+	withoutProof := "c=biws,r=" + nonce
+	authMessage := []byte(bare + "," + msg + "," + withoutProof)
+
+	proof := hmacSum(a.hash, storedKey.Sum(nil), authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+
+	serverKey := hmacSum(a.hash, salted, []byte("Server Key"))
+	a.serverSignature = base64.StdEncoding.EncodeToString(hmacSum(a.hash, serverKey, authMessage))
+
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// verifyServer verifies the server signature in the server-final-message msg,
+// proving the server knows the password.
+func (a *scramAuth) verifyServer(msg string) ([]byte, error) {
+	signature := strings.SplitN(msg[2:], ",", 2)[0]
+
+	want := a.serverSignature
+	a.serverSignature = ""
+	if want == "" || !hmac.Equal([]byte(signature), []byte(want)) {
+		return nil, errors.New("mailyak: invalid scram server signature")
+	}
+
+	a.verified = true
+
+	// The client sends an empty response to complete the exchange.
+	return []byte{}, nil
+}
+
+// hmacSum returns the HMAC of data using key.
+func hmacSum(h func() hash.Hash, key, data []byte) []byte {
+	mac := hmac.New(h, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// pbkdf2 derives a key from password and salt (RFC 8018 section 5.2), returning
+// a key the length of the hash output - the Hi() function of RFC 5802.
+func pbkdf2(h func() hash.Hash, password, salt []byte, iterations int) []byte {
+	mac := hmac.New(h, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	out := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
@@ -0,0 +1,289 @@
+package mailyak
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"net/smtp"
+	"testing"
+	"time"
+)
+
+// fixedNonce returns a nonce func that always returns nonce.
+func fixedNonce(nonce string) func() (string, error) {
+	return func() (string, error) { return nonce, nil }
+}
+
+// TestScramAuth ensures the SCRAM exchange matches the RFC 5802 and RFC 7677
+// test vectors.
+func TestScramAuth(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		auth        *scramAuth
+		nonce       string
+		serverFirst string
+		clientFinal string
+		serverFinal string
+	}{
+		{
+			name:        "SCRAM-SHA-1 (RFC 5802)",
+			auth:        ScramSHA1Auth("user", "pencil").(*scramAuth),
+			nonce:       "fyko+d2lbbFgONRv9qkxdawL",
+			serverFirst: "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096",
+			clientFinal: "c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=",
+			serverFinal: "v=rmF9pqV8S7suAoZWja4dJRkFsKQ=",
+		},
+		{
+			name:        "SCRAM-SHA-256 (RFC 7677)",
+			auth:        ScramSHA256Auth("user", "pencil").(*scramAuth),
+			nonce:       "rOprNGfwEbeRWgbNEkqO",
+			serverFirst: "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096",
+			clientFinal: "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=",
+			serverFinal: "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.auth.nonce = fixedNonce(tt.nonce)
+
+			mech, resp, err := tt.auth.Start(&smtp.ServerInfo{Name: "mail.host.com"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mech != tt.auth.mechanism {
+				t.Errorf("mechanism = %q, want %q", mech, tt.auth.mechanism)
+			}
+			if want := "n,,n=user,r=" + tt.nonce; string(resp) != want {
+				t.Errorf("client-first = %q, want %q", resp, want)
+			}
+
+			resp, err = tt.auth.Next([]byte(tt.serverFirst), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(resp) != tt.clientFinal {
+				t.Errorf("client-final = %q, want %q", resp, tt.clientFinal)
+			}
+
+			resp, err = tt.auth.Next([]byte(tt.serverFinal), true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil || len(resp) != 0 {
+				t.Errorf("response to server-final = %q, want empty", resp)
+			}
+
+			if resp, err := tt.auth.Next([]byte("2.7.0 Authentication successful"), false); resp != nil || err != nil {
+				t.Errorf("Next() = %q, %v, want nil", resp, err)
+			}
+		})
+	}
+}
+
+// TestScramAuth_errors ensures invalid server messages, including an invalid
+// server signature, fail the authentication.
+func TestScramAuth_errors(t *testing.T) {
+	t.Parallel()
+
+	const (
+		nonce       = "fyko+d2lbbFgONRv9qkxdawL"
+		serverFirst = "r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"
+	)
+
+	tests := []struct {
+		name     string
+		messages []string
+	}{
+		{
+			name:     "wrong server signature",
+			messages: []string{serverFirst, "v=" + base64.StdEncoding.EncodeToString([]byte("not the signature!!!"))},
+		},
+		{
+			name:     "success without server signature",
+			messages: []string{serverFirst, ""},
+		},
+		{
+			name:     "server nonce does not extend client nonce",
+			messages: []string{"r=bananas,s=QSXCR+Q6sek8bf92,i=4096"},
+		},
+		{
+			name:     "server nonce equal to client nonce",
+			messages: []string{"r=" + nonce + ",s=QSXCR+Q6sek8bf92,i=4096"},
+		},
+		{
+			name:     "invalid iteration count",
+			messages: []string{"r=" + nonce + "x,s=QSXCR+Q6sek8bf92,i=0"},
+		},
+		{
+			name:     "invalid salt",
+			messages: []string{"r=" + nonce + "x,s=!!,i=4096"},
+		},
+		{
+			name:     "mandatory extension",
+			messages: []string{"m=ext,r=" + nonce + "x,s=QSXCR+Q6sek8bf92,i=4096"},
+		},
+		{
+			name:     "server error",
+			messages: []string{serverFirst, "e=invalid-proof"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			auth := ScramSHA1Auth("user", "pencil").(*scramAuth)
+			auth.nonce = fixedNonce(nonce)
+			if _, _, err := auth.Start(&smtp.ServerInfo{Name: "mail.host.com"}); err != nil {
+				t.Fatal(err)
+			}
+
+			var err error
+			for i, msg := range tt.messages {
+				// An empty message is the final success reply.
+				more := msg != "" || i < len(tt.messages)-1
+				if _, err = auth.Next([]byte(msg), more); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+// TestScramAuth_concurrent ensures interleaved exchanges using the same
+// smtp.Auth, such as by a Pool, are tracked independently - a server reporting
+// success without sending its signature is rejected, even if another exchange
+// has verified the server signature.
+func TestScramAuth_concurrent(t *testing.T) {
+	t.Parallel()
+
+	shared := ScramSHA1Auth("user", "pencil").(*scramAuth)
+
+	// Start an exchange with a different nonce before the RFC 5802 exchange.
+	shared.nonce = fixedNonce("another-nonce")
+	other := shared.newExchange()
+	if _, _, err := other.Start(&smtp.ServerInfo{Name: "mail.host.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	shared.nonce = fixedNonce("fyko+d2lbbFgONRv9qkxdawL")
+	auth := shared.newExchange()
+	if _, _, err := auth.Start(&smtp.ServerInfo{Name: "mail.host.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := auth.Next([]byte("r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The other exchange receives its server-first message.
+	if _, err := other.Next([]byte("r=another-nonce123,s=QSXCR+Q6sek8bf92,i=1"), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server signature of one exchange is not accepted by the other.
+	if _, err := other.Next([]byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ="), true); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := auth.Next([]byte("v=rmF9pqV8S7suAoZWja4dJRkFsKQ="), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// The other exchange has not verified its server signature.
+	if _, err := other.Next([]byte("OK"), false); err == nil {
+		t.Fatal("expected error")
+	}
+
+	if _, err := auth.Next([]byte("OK"), false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestScramAuth_exchange ensures the SCRAM exchange is completed with an SMTP
+// server.
+func TestScramAuth_exchange(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	b64 := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+
+		conn, err := socket.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		c := newConnAsserts(conn, t)
+		c.Respond("220 localhost ESMTP bananas\r\n")
+
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250-localhost Hola\r\n")
+		c.Respond("250 AUTH SCRAM-SHA-256\r\n")
+
+		c.Expect("AUTH SCRAM-SHA-256 " + b64("n,,n=user,r=rOprNGfwEbeRWgbNEkqO") + "\r\n")
+		c.Respond("334 " + b64("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096") + "\r\n")
+		c.Expect(b64("c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=") + "\r\n")
+		c.Respond("334 " + b64("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") + "\r\n")
+		c.Expect("\r\n")
+		c.Respond("235 2.7.0 Authentication successful\r\n")
+
+		c.Expect("MAIL FROM:<from@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("RCPT TO:<to@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("DATA\r\n")
+		c.Respond("354 OK\r\n")
+		c.Expect("bananas\r\n.\r\n")
+		c.Respond("250 Will do friend\r\n")
+		c.Expect("QUIT\r\n")
+		c.Respond("221 Adios\r\n")
+	}()
+
+	auth := ScramSHA256Auth("user", "pencil").(*scramAuth)
+	auth.nonce = fixedNonce("rOprNGfwEbeRWgbNEkqO")
+
+	err = newSenderWithStartTLS(socket.Addr().String()).Send(context.Background(), &mockMail{
+		toAddrs:  []string{"to@example.org"},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+		auth:     auth,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-handlerDone:
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for SMTP conversation to complete")
+	}
+
+	// The exchange uses a new scramAuth, so the shared smtp.Auth holds no
+	// exchange state.
+	if auth.clientNonce != "" || auth.serverSignature != "" || auth.verified {
+		t.Error("exchange state stored in the shared smtp.Auth")
+	}
+}
@@ -2,92 +2,272 @@ package mailyak
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"errors"
 	"io"
 	"net"
 	"net/smtp"
+	"net/textproto"
+	"time"
 )
 
-// emailSender abstracts the connection and protocol conversation required to
-// send an email with a remote SMTP server.
-type emailSender interface {
-	Send(m sendableMail) error
+// Sender abstracts the transport used to deliver an email, such as the
+// connection and protocol conversation required to send an email with a remote
+// SMTP server.
+//
+// MailYak uses an SMTP Sender by default - alternative transports (such as an
+// HTTP email API, a file drop or a test double) can be used by implementing
+// Sender and configuring it with UseSender().
+type Sender interface {
+	// Send should deliver m, aborting the attempt if ctx is cancelled or its
+	// deadline expires.
+	Send(ctx context.Context, m SendableMail) error
 }
 
-// sendableMail provides a set of methods to describe an email to a SMTP server.
-type sendableMail interface {
-	// getLocalName should return the sender domain to be used in the EHLO/HELO
-	// command.
-	getLocalName() string
+// SendableMail provides a set of methods to describe an email to a Sender.
+type SendableMail interface {
+	// Envelope should return the SMTP envelope information for the email.
+	Envelope() Envelope
 
-	// getToAddrs should return a slice of email addresses to be added to the
-	// RCPT TO command.
-	getToAddrs() []string
+	// WriteMime should write the generated MIME to w.
+	//
+	// The Sender implementation is responsible for providing appropriate
+	// buffering of writes.
+	WriteMime(w io.Writer) error
+}
 
-	// getFromAddr should return the address to be used in the MAIL FROM
-	// command.
-	getFromAddr() string
+// Envelope describes the information used to transport an email, separate to
+// the MIME content of the email itself.
+type Envelope struct {
+	// LocalName is the sender domain to be used in the EHLO/HELO command, or
+	// an empty string to use the default.
+	LocalName string
 
-	// getAuth should return the smtp.Auth if configured, nil if not.
-	getAuth() smtp.Auth
+	// From is the address to be used in the MAIL FROM command.
+	From string
 
-	// buildMime should write the generated MIME to w.
-	//
-	// The emailSender implementation is responsible for providing appropriate
-	// buffering of writes.
-	buildMime(w io.Writer) error
+	// To is the list of email addresses to be added to the RCPT TO command,
+	// including any CC and BCC recipients.
+	To []string
+
+	// Auth is the smtp.Auth to authenticate with if configured, nil if not.
+	Auth smtp.Auth
+
+	// AllowPartialDelivery indicates the email should be delivered to all
+	// recipients accepted by the server, even if some are rejected. See
+	// MailYak.AllowPartialDelivery() for details.
+	AllowPartialDelivery bool
+}
+
+// aLongTimeAgo is a non-zero time in the past, used to immediately unblock any
+// I/O operations on a net.Conn by setting it as the deadline.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watchConn applies the deadline of ctx (if any) to conn, and unblocks any
+// reads or writes in progress on conn when ctx is cancelled.
+//
+// The returned stop func must be called once the I/O bound by ctx is complete,
+// and clears any deadline set on conn.
+func watchConn(ctx context.Context, conn net.Conn) (stop func()) {
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	var (
+		done   = make(chan struct{})
+		exited = make(chan struct{})
+	)
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(aLongTimeAgo)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-exited
+		_ = conn.SetDeadline(time.Time{})
+	}
+}
+
+// contextError returns err annotated with the ctx error if ctx has been
+// cancelled or its deadline has expired, allowing callers to use errors.Is to
+// match context.Canceled or context.DeadlineExceeded.
+//
+// If ctx is still valid, or err is nil, err is returned unchanged.
+func contextError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	ctxErr := ctx.Err()
+	if ctxErr == nil {
+		// The connection deadline derived from ctx can expire fractionally
+		// before ctx itself is marked as done.
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			ctxErr = context.DeadlineExceeded
+		}
+	}
+	if ctxErr == nil || errors.Is(err, ctxErr) {
+		return err
+	}
+
+	return &contextCauseError{ctxErr: ctxErr, err: err}
+}
+
+// contextCauseError is an error caused by ctxErr, such as a network error
+// returned when the connection was interrupted by a cancelled context.
+//
+// It matches both ctxErr and the underlying err with errors.Is, and err with
+// errors.As.
+type contextCauseError struct {
+	ctxErr error
+	err    error
+}
+
+func (e *contextCauseError) Error() string {
+	return e.ctxErr.Error() + ": " + e.err.Error()
+}
+
+// Is returns true if target is the context error.
+func (e *contextCauseError) Is(target error) bool {
+	return target == e.ctxErr
+}
+
+// Unwrap returns the underlying error.
+func (e *contextCauseError) Unwrap() error {
+	return e.err
 }
 
 // smtpExchange performs the SMTP protocol conversation necessary to send m over
 // conn.
 //
-// serverName must be the hostname (or IP address) of the remote endpoint.
-func smtpExchange(m sendableMail, conn net.Conn, serverName string, tryTLSUpgrade bool) error {
+// serverName must be the hostname (or IP address) of the remote endpoint. The
+// connection is upgraded with STARTTLS using tlsConfig according to tlsPolicy -
+// connections already using TLS should use TLSDisabled.
+//
+// The caller is responsible for ensuring I/O on conn respects ctx (see
+// watchConn) - any error returned is a *SMTPError annotated with the ctx error
+// if ctx is done, or a *PartialDeliveryError.
+func smtpExchange(ctx context.Context, m SendableMail, conn net.Conn, serverName string, tlsPolicy TLSPolicy, tlsConfig *tls.Config) error {
 	// Connect to the SMTP server
 	c, err := smtp.NewClient(conn, serverName)
 	if err != nil {
-		return err
+		return newSMTPError(ctx, StageHello, err)
 	}
 	defer func() { _ = c.Quit() }()
 
-	if localName := m.getLocalName(); localName != "" {
-		if err := c.Hello(localName); err != nil {
-			return err
-		}
+	env := m.Envelope()
+
+	if err := smtpHandshake(ctx, c, env, tlsPolicy, tlsConfig); err != nil {
+		return err
 	}
 
-	if tryTLSUpgrade {
-		if ok, _ := c.Extension("STARTTLS"); ok {
-			//nolint:gosec
-			config := &tls.Config{
-				ServerName: serverName,
-			}
-			if err = c.StartTLS(config); err != nil {
-				return err
+	return smtpTransaction(ctx, c, env, m)
+}
+
+// smtpHandshake prepares c for sending emails described by env, sending the
+// EHLO/HELO, upgrading the connection with STARTTLS using tlsConfig according
+// to tlsPolicy, and authenticating if env includes credentials.
+func smtpHandshake(ctx context.Context, c *smtp.Client, env Envelope, tlsPolicy TLSPolicy, tlsConfig *tls.Config) error {
+	// Always send the EHLO/HELO explicitly (rather than letting the client
+	// send it as part of the next command) so a failure is correctly
+	// attributed to StageHello.
+	localName := env.LocalName
+	if localName == "" {
+		// The default used by smtp.NewClient.
+		localName = "localhost"
+	}
+	if err := c.Hello(localName); err != nil {
+		return newSMTPError(ctx, StageHello, err)
+	}
+
+	if tlsPolicy != TLSDisabled {
+		ok, _ := c.Extension("STARTTLS")
+
+		// Fail closed for any policy other than TLSOpportunistic, before the
+		// credentials are sent.
+		if !ok && tlsPolicy != TLSOpportunistic {
+			return newSMTPError(ctx, StageStartTLS, ErrStartTLSNotSupported)
+		}
+
+		if ok {
+			if err := c.StartTLS(tlsConfig); err != nil {
+				return newSMTPError(ctx, StageStartTLS, err)
 			}
 		}
 	}
 
 	// Attempt to authenticate if credentials were provided
 	var nilAuth smtp.Auth
-	if auth := m.getAuth(); auth != nilAuth {
-		if err = c.Auth(auth); err != nil {
-			return err
+	if auth := env.Auth; auth != nilAuth {
+		if ea, ok := auth.(exchangeAuth); ok {
+			auth = ea.newExchange()
+		}
+		if err := c.Auth(auth); err != nil {
+			return newSMTPError(ctx, StageAuth, err)
 		}
 	}
 
+	return nil
+}
+
+// smtpTransaction sends the MAIL, RCPT and DATA commands over c (which must
+// have completed the smtpHandshake) to deliver m to the recipients in env.
+func smtpTransaction(ctx context.Context, c *smtp.Client, env Envelope, m SendableMail) error {
 	// Set the from address
-	if err = c.Mail(m.getFromAddr()); err != nil {
-		return err
+	if err := c.Mail(env.From); err != nil {
+		return newSMTPError(ctx, StageMail, err)
 	}
 
 	// Add all the recipients
-	for _, to := range m.getToAddrs() {
-		if err = c.Rcpt(to); err != nil {
-			return err
+	var (
+		accepted = make([]string, 0, len(env.To))
+		rejected []*RecipientError
+	)
+	for _, to := range env.To {
+		err := c.Rcpt(to)
+
+		// Record recipients rejected by the server if partial delivery is
+		// enabled, rather than aborting the send.
+		var reply *textproto.Error
+		if err != nil && env.AllowPartialDelivery && errors.As(err, &reply) {
+			rejected = append(rejected, newRecipientError(to, reply))
+			continue
+		}
+		if err != nil {
+			return newSMTPError(ctx, StageRcpt, err)
+		}
+
+		accepted = append(accepted, to)
+	}
+
+	var partialErr error
+	if len(rejected) > 0 {
+		partialErr = &PartialDeliveryError{
+			Accepted: accepted,
+			Rejected: rejected,
 		}
 	}
 
+	// There's nothing to send if no recipients were accepted.
+	if len(accepted) == 0 && partialErr != nil {
+		return partialErr
+	}
+
+	if err := smtpData(c, m); err != nil {
+		return newSMTPError(ctx, StageData, err)
+	}
+
+	return partialErr
+}
+
+// smtpData sends the DATA command over c and writes the MIME content of m.
+func smtpData(c *smtp.Client, m SendableMail) error {
 	// Start the data session and write the email body
 	dataSession, err := c.Data()
 	if err != nil {
@@ -97,10 +277,15 @@ func smtpExchange(m sendableMail, conn net.Conn, serverName string, tryTLSUpgrad
 	// Wrap the socket in a small buffer (~4k) to avoid making lots of small
 	// syscalls and therefore reducing CPU usage.
 	buf := bufio.NewWriter(dataSession)
-	if err := m.buildMime(buf); err != nil {
-		return err
+	err = m.WriteMime(buf)
+	if err == nil {
+		err = buf.Flush()
 	}
-	if err := buf.Flush(); err != nil {
+	if err != nil {
+		// Closing the DATA stream (or sending QUIT, which closes it) would
+		// terminate it and cause the server to accept the partial email -
+		// drop the connection instead.
+		_ = c.Close()
 		return err
 	}
 
@@ -1,6 +1,7 @@
 package mailyak
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 )
@@ -14,19 +15,57 @@ type senderExplicitTLS struct {
 
 	// tlsConfig is always non-nil
 	tlsConfig *tls.Config
+
+	// inferredServerName is true when the tlsConfig ServerName was derived
+	// from hostAndPort, rather than provided by the user.
+	inferredServerName bool
 }
 
 // Connect to the SMTP host configured in m, and send the email.
-func (s *senderExplicitTLS) Send(m sendableMail) error {
-	conn, err := tls.Dial("tcp", s.hostAndPort, s.tlsConfig)
+func (s *senderExplicitTLS) Send(ctx context.Context, m SendableMail) error {
+	return retryTokenAuth(m.Envelope().Auth, func() error {
+		return s.send(ctx, m)
+	})
+}
+
+// send connects to the SMTP server and sends m.
+func (s *senderExplicitTLS) send(ctx context.Context, m SendableMail) error {
+	conn, err := dialTLS(ctx, s.hostAndPort, s.tlsConfig)
 	if err != nil {
 		return err
 	}
 	defer func() { _ = conn.Close() }()
 
+	stop := watchConn(ctx, conn)
+	defer stop()
+
 	// Perform the SMTP protocol conversation, using the provided TLS ServerName
-	// as the SMTP server name.
-	return smtpExchange(m, conn, s.hostname, false)
+	// as the SMTP server name. The connection is already using TLS, so there
+	// is no STARTTLS upgrade.
+	return smtpExchange(ctx, m, conn, s.hostname, TLSDisabled, nil)
+}
+
+// dialTLS connects to hostAndPort and performs a TLS handshake using
+// tlsConfig, aborting if ctx is done.
+func dialTLS(ctx context.Context, hostAndPort string, tlsConfig *tls.Config) (*tls.Conn, error) {
+	var d net.Dialer
+	rawConn, err := d.DialContext(ctx, "tcp", hostAndPort)
+	if err != nil {
+		return nil, newSMTPError(ctx, StageDial, err)
+	}
+
+	// Watch the underlying connection so the TLS handshake is also bound by
+	// ctx.
+	stop := watchConn(ctx, rawConn)
+	defer stop()
+
+	conn := tls.Client(rawConn, tlsConfig)
+	if err := conn.Handshake(); err != nil {
+		_ = rawConn.Close()
+		return nil, newSMTPError(ctx, StageDial, err)
+	}
+
+	return conn, nil
 }
 
 // newSenderWithExplicitTLS constructs a new senderExplicitTLS.
@@ -43,22 +82,52 @@ func newSenderWithExplicitTLS(hostAndPort string, tlsConfig *tls.Config) (*sende
 		return nil, err
 	}
 
-	if tlsConfig != nil {
-		// Clone the user-provided TLS config to prevent it being
-		// mutated by the caller.
-		tlsConfig = tlsConfig.Clone()
-	} else {
-		// If there is no TLS config provided, initialise a default.
-		//nolint:gosec // Maximum compatability but please use TLS >= 1.2
-		tlsConfig = &tls.Config{
-			ServerName: hostName,
-		}
-	}
+	tlsConfig, inferred := cloneTLSConfig(tlsConfig, hostName)
 
 	return &senderExplicitTLS{
 		hostAndPort: hostAndPort,
 		hostname:    hostName,
 
-		tlsConfig: tlsConfig,
+		tlsConfig:          tlsConfig,
+		inferredServerName: inferred,
 	}, nil
 }
+
+// withHost returns a copy of s that connects to hostAndPort, using the same
+// TLS configuration.
+func (s *senderExplicitTLS) withHost(hostAndPort string) (Sender, error) {
+	tlsConfig := s.tlsConfig.Clone()
+	if s.inferredServerName {
+		tlsConfig.ServerName = ""
+	}
+
+	return newSenderWithExplicitTLS(hostAndPort, tlsConfig)
+}
+
+// cloneTLSConfig returns a copy of tlsConfig to prevent it being mutated by the
+// caller, inferring the ServerName from hostName if not explicitly set.
+//
+// If tlsConfig is nil, a sensible default with maximum compatability is
+// generated. inferred is true if the ServerName was derived from hostName.
+func cloneTLSConfig(tlsConfig *tls.Config, hostName string) (config *tls.Config, inferred bool) {
+	if tlsConfig == nil {
+		// If there is no TLS config provided, initialise a default.
+		//nolint:gosec // Maximum compatability but please use TLS >= 1.2
+		return &tls.Config{
+			ServerName: hostName,
+		}, true
+	}
+
+	// Clone the user-provided TLS config to prevent it being mutated by the
+	// caller.
+	config = tlsConfig.Clone()
+
+	// Mirror the behaviour of tls.Dial, inferring the ServerName from the host
+	// if not explicitly set.
+	if config.ServerName == "" {
+		config.ServerName = hostName
+		return config, true
+	}
+
+	return config, false
+}
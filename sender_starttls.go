@@ -2,25 +2,90 @@ package mailyak
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"net"
 )
 
+// TLSPolicy controls whether connections made by the STARTTLS sender are
+// upgraded to TLS.
+type TLSPolicy int
+
+// The TLS policies supported by SetTLSPolicy.
+const (
+	// TLSOpportunistic upgrades the connection with STARTTLS if the server
+	// supports it, and otherwise sends the email in plaintext.
+	//
+	// This is the default policy, but is vulnerable to an attacker able to
+	// modify the connection removing the STARTTLS extension from the server
+	// response to downgrade the connection to plaintext.
+	TLSOpportunistic TLSPolicy = iota
+
+	// TLSRequired upgrades the connection with STARTTLS, failing the send
+	// with ErrStartTLSNotSupported (before any credentials or email content
+	// are sent) if the server does not support it.
+	TLSRequired
+
+	// TLSDisabled never upgrades the connection, sending the email in
+	// plaintext.
+	TLSDisabled
+)
+
+// String returns the name of the policy.
+func (p TLSPolicy) String() string {
+	switch p {
+	case TLSOpportunistic:
+		return "opportunistic"
+	case TLSRequired:
+		return "required"
+	case TLSDisabled:
+		return "disabled"
+	}
+	return "unknown"
+}
+
+// ErrStartTLSNotSupported is returned (wrapped in a *SMTPError) when the
+// TLSRequired policy is used and the server does not support STARTTLS.
+var ErrStartTLSNotSupported = errors.New("mailyak: server does not support STARTTLS")
+
 // senderWithStartTLS connects to the remote SMTP server, upgrades the
 // connection using STARTTLS if available, and sends the email.
 type senderWithStartTLS struct {
 	hostAndPort string
 	hostname    string
 	buf         *bytes.Buffer
+
+	// tlsPolicy controls whether the connection is upgraded with STARTTLS.
+	tlsPolicy TLSPolicy
+
+	// tlsConfig is used for the STARTTLS upgrade, and is always non-nil.
+	tlsConfig *tls.Config
+
+	// inferredServerName is true when the tlsConfig ServerName was derived
+	// from hostAndPort, rather than provided by the user.
+	inferredServerName bool
 }
 
-func (s *senderWithStartTLS) Send(m sendableMail) error {
-	conn, err := net.Dial("tcp", s.hostAndPort)
+func (s *senderWithStartTLS) Send(ctx context.Context, m SendableMail) error {
+	return retryTokenAuth(m.Envelope().Auth, func() error {
+		return s.send(ctx, m)
+	})
+}
+
+// send connects to the SMTP server and sends m.
+func (s *senderWithStartTLS) send(ctx context.Context, m SendableMail) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.hostAndPort)
 	if err != nil {
-		return err
+		return newSMTPError(ctx, StageDial, err)
 	}
 	defer func() { _ = conn.Close() }()
 
-	return smtpExchange(m, conn, s.hostname, true)
+	stop := watchConn(ctx, conn)
+	defer stop()
+
+	return smtpExchange(ctx, m, conn, s.hostname, s.tlsPolicy, s.tlsConfig)
 }
 
 func newSenderWithStartTLS(hostAndPort string) *senderWithStartTLS {
@@ -39,9 +104,117 @@ func newSenderWithStartTLS(hostAndPort string) *senderWithStartTLS {
 		hostName = hostAndPort
 	}
 
-	return &senderWithStartTLS{
+	s := &senderWithStartTLS{
 		hostAndPort: hostAndPort,
 		hostname:    hostName,
 		buf:         &bytes.Buffer{},
+		tlsPolicy:   TLSOpportunistic,
+	}
+	s.setTLSConfig(nil)
+
+	return s
+}
+
+// setTLSConfig configures s to use a copy of tlsConfig for the STARTTLS
+// upgrade, or a sensible default if tlsConfig is nil.
+func (s *senderWithStartTLS) setTLSConfig(tlsConfig *tls.Config) {
+	s.tlsConfig, s.inferredServerName = cloneTLSConfig(tlsConfig, s.hostname)
+}
+
+// withHost returns a copy of s that connects to hostAndPort, using the same
+// TLS policy and configuration.
+func (s *senderWithStartTLS) withHost(hostAndPort string) (Sender, error) {
+	tlsConfig := s.tlsConfig.Clone()
+	if s.inferredServerName {
+		tlsConfig.ServerName = ""
+	}
+
+	out := newSenderWithStartTLS(hostAndPort)
+	out.tlsPolicy = s.tlsPolicy
+	out.setTLSConfig(tlsConfig)
+
+	return out, nil
+}
+
+// SetTLSPolicy configures whether the connection to the SMTP server is
+// upgraded to TLS with STARTTLS, for MailYak instances created with New().
+//
+// By default the connection is upgraded only if the server supports STARTTLS
+// (TLSOpportunistic). Use TLSRequired to refuse to send the email (or any
+// credentials) over a plaintext connection:
+//
+//	mail := mailyak.New("smtp.itsallbroken.com:25", auth)
+//	if err := mail.SetTLSPolicy(mailyak.TLSRequired); err != nil {
+//	    return err
+//	}
+//
+// The policy also applies to any failover hosts configured with
+// SetRetryPolicy(). An error is returned if m was created with NewWithTLS()
+// (which always uses TLS) or uses a custom Sender set with UseSender().
+func (m *MailYak) SetTLSPolicy(policy TLSPolicy) error {
+	senders, err := m.startTLSSenders()
+	if err != nil {
+		return err
 	}
+
+	for _, s := range senders {
+		s.tlsPolicy = policy
+	}
+
+	return nil
+}
+
+// SetStartTLSConfig configures the tls.Config used to upgrade the connection to
+// the SMTP server with STARTTLS, for MailYak instances created with New().
+//
+// This allows a custom root CA to be trusted, a minimum TLS version to be set,
+// or a client certificate to be presented to the server:
+//
+//	mail := mailyak.New("relay.itsallbroken.com:587", nil)
+//	err := mail.SetStartTLSConfig(&tls.Config{
+//	    RootCAs:      internalCAs,
+//	    Certificates: []tls.Certificate{clientCert},
+//	    MinVersion:   tls.VersionTLS12,
+//	})
+//
+// tlsConfig is cloned, and if the ServerName is not set it is inferred from
+// the SMTP server host (and the host of any failover hosts configured with
+// SetRetryPolicy()). If tlsConfig is nil, the default configuration is
+// restored.
+//
+// The connection is only upgraded if permitted by the TLS policy - use
+// SetTLSPolicy(TLSRequired) to ensure the tls.Config is always used. An error
+// is returned if m was created with NewWithTLS() (which accepts a tls.Config
+// directly) or uses a custom Sender set with UseSender().
+func (m *MailYak) SetStartTLSConfig(tlsConfig *tls.Config) error {
+	senders, err := m.startTLSSenders()
+	if err != nil {
+		return err
+	}
+
+	for _, s := range senders {
+		s.setTLSConfig(tlsConfig)
+	}
+
+	return nil
+}
+
+// startTLSSenders returns the STARTTLS senders used by m, including those of
+// any failover hosts, or an error if m is not configured to use them.
+func (m *MailYak) startTLSSenders() ([]*senderWithStartTLS, error) {
+	relays := []relay{{host: m.host, sender: m.sender}}
+	if rs, ok := m.sender.(*retrySender); ok {
+		relays = rs.relays
+	}
+
+	senders := make([]*senderWithStartTLS, 0, len(relays))
+	for _, r := range relays {
+		s, ok := r.sender.(*senderWithStartTLS)
+		if !ok {
+			return nil, errors.New("mailyak: starttls options are not supported by the configured sender")
+		}
+		senders = append(senders, s)
+	}
+
+	return senders, nil
 }
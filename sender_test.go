@@ -9,12 +9,16 @@ import (
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/big"
 	"net"
 	"net/smtp"
+	"net/textproto"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -116,7 +120,7 @@ func newConnAsserts(c net.Conn, t *testing.T) *connAsserts {
 	}
 }
 
-// mockMail provides the methods for a sendableMail, allowing for deterministic
+// mockMail provides the methods for a SendableMail, allowing for deterministic
 // MIME content in tests.
 type mockMail struct {
 	localName string
@@ -124,37 +128,101 @@ type mockMail struct {
 	fromAddr  string
 	auth      smtp.Auth
 	mime      string
+
+	allowPartial bool
 }
 
-// getLocalName should return the sender domain to be used in the EHLO/HELO
-// command.
-func (m *mockMail) getLocalName() string {
-	return m.localName
+// Envelope returns the SMTP envelope information for the mock email.
+func (m *mockMail) Envelope() Envelope {
+	return Envelope{
+		LocalName: m.localName,
+		From:      m.fromAddr,
+		To:        stripNames(m.toAddrs),
+		Auth:      m.auth,
+
+		AllowPartialDelivery: m.allowPartial,
+	}
 }
 
-// toAddrs should return a slice of email addresses to be added to the RCPT
-// TO command.
-func (m *mockMail) getToAddrs() []string {
-	return stripNames(m.toAddrs)
+// WriteMime writes the mock MIME content to w.
+func (m *mockMail) WriteMime(w io.Writer) error {
+	_, err := w.Write([]byte(m.mime))
+	return err
 }
 
-// fromAddr should return the address to be used in the MAIL FROM command.
-func (m *mockMail) getFromAddr() string {
-	return m.fromAddr
+// TestSMTPDataAbort ensures a failure writing the MIME content drops the
+// connection without terminating the DATA stream, so the server does not
+// accept the partial email.
+func TestSMTPDataAbort(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := socket.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		c := newConnAsserts(conn, t)
+		c.Respond("220 localhost ESMTP bananas\r\n")
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250 localhost Hola\r\n")
+		c.Expect("MAIL FROM:<from@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("RCPT TO:<to@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("DATA\r\n")
+		c.Respond("354 OK\r\n")
+
+		// Read everything sent until the connection is closed.
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	wantErr := errors.New("attachment went missing")
+	err = newSenderWithStartTLS(socket.Addr().String()).Send(ctx, &partialMail{
+		mockMail: mockMail{
+			toAddrs:  []string{"to@example.org"},
+			fromAddr: "from@example.org",
+			mime:     strings.Repeat("bananas\r\n", 1024),
+		},
+		err: wantErr,
+	})
+	if !errors.Is(err, wantErr) {
+		t.Fatalf("got %v, want %v", err, wantErr)
+	}
+
+	select {
+	case data := <-received:
+		if strings.Contains(data, "\r\n.\r\n") || strings.Contains(data, "QUIT") {
+			t.Errorf("DATA stream terminated, server received %q", data[len(data)-32:])
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for the connection to be closed")
+	}
 }
 
-// auth should return the smtp.Auth if configured, nil if not.
-func (m *mockMail) getAuth() smtp.Auth {
-	return m.auth
+// partialMail is a SendableMail that fails after writing its MIME content.
+type partialMail struct {
+	mockMail
+	err error
 }
 
-// buildMime should write the generated MIME to w.
-//
-// The emailSender implementation is responsible for providing appropriate
-// buffering of writes.
-func (m *mockMail) buildMime(w io.Writer) error {
-	_, err := w.Write([]byte(m.mime))
-	return err
+func (m *partialMail) WriteMime(w io.Writer) error {
+	if err := m.mockMail.WriteMime(w); err != nil {
+		return err
+	}
+	return m.err
 }
 
 // TestSMTPProtocolExchange sends the same mock email over two different
@@ -311,6 +379,138 @@ func TestSMTPProtocolExchange(t *testing.T) {
 			wantTLSErr:       nil,
 			wantPlaintextErr: nil,
 		},
+		{
+			name: "rcpt rejected",
+			mail: &mockMail{
+				toAddrs: []string{
+					"to@example.org",
+					"another@example.com",
+				},
+				fromAddr: "from@example.org",
+				mime:     "bananas",
+			},
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250-localhost Hola\r\n")
+				c.Respond("250 AUTH LOGIN PLAIN\r\n")
+
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				c.Respond("550 5.1.1 No such user\r\n")
+
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantTLSErr: &SMTPError{
+				Stage:        StageRcpt,
+				Code:         550,
+				EnhancedCode: "5.1.1",
+				Message:      "No such user",
+				Err:          &textproto.Error{Code: 550, Msg: "5.1.1 No such user"},
+			},
+			wantPlaintextErr: &SMTPError{
+				Stage:        StageRcpt,
+				Code:         550,
+				EnhancedCode: "5.1.1",
+				Message:      "No such user",
+				Err:          &textproto.Error{Code: 550, Msg: "5.1.1 No such user"},
+			},
+		},
+		{
+			name: "partial delivery",
+			mail: &mockMail{
+				toAddrs: []string{
+					"to@example.org",
+					"another@example.com",
+					"Dom <dom@itsallbroken.com>",
+				},
+				fromAddr:     "from@example.org",
+				mime:         "bananas",
+				allowPartial: true,
+			},
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250-localhost Hola\r\n")
+				c.Respond("250 AUTH LOGIN PLAIN\r\n")
+
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				c.Respond("550 5.1.1 No such user\r\n")
+
+				c.Expect("RCPT TO:<another@example.com>\r\n")
+				c.Respond("250 OK\r\n")
+
+				c.Expect("RCPT TO:<dom@itsallbroken.com>\r\n")
+				c.Respond("452 Too many recipients\r\n")
+
+				c.Expect("DATA\r\n")
+				c.Respond("354 OK\r\n")
+				c.Expect("bananas\r\n.\r\n")
+				c.Respond("250 Will do friend\r\n")
+
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantTLSErr: &PartialDeliveryError{
+				Accepted: []string{"another@example.com"},
+				Rejected: []*RecipientError{
+					{Address: "to@example.org", Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+					{Address: "dom@itsallbroken.com", Code: 452, Message: "Too many recipients"},
+				},
+			},
+			wantPlaintextErr: &PartialDeliveryError{
+				Accepted: []string{"another@example.com"},
+				Rejected: []*RecipientError{
+					{Address: "to@example.org", Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+					{Address: "dom@itsallbroken.com", Code: 452, Message: "Too many recipients"},
+				},
+			},
+		},
+		{
+			name: "partial delivery all rejected",
+			mail: &mockMail{
+				toAddrs:      []string{"to@example.org"},
+				fromAddr:     "from@example.org",
+				mime:         "bananas",
+				allowPartial: true,
+			},
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250-localhost Hola\r\n")
+				c.Respond("250 AUTH LOGIN PLAIN\r\n")
+
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+				c.Respond("550 5.1.1 No such user\r\n")
+
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantTLSErr: &PartialDeliveryError{
+				Accepted: []string{},
+				Rejected: []*RecipientError{
+					{Address: "to@example.org", Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+				},
+			},
+			wantPlaintextErr: &PartialDeliveryError{
+				Accepted: []string{},
+				Rejected: []*RecipientError{
+					{Address: "to@example.org", Code: 550, EnhancedCode: "5.1.1", Message: "No such user"},
+				},
+			},
+		},
 	}
 
 	// handleConn provides the accept loop for both the TLS server, and the
@@ -380,10 +580,10 @@ func TestSMTPProtocolExchange(t *testing.T) {
 				}
 
 				// Call into the sender directly, giving it the mock
-				// sendableEmail
+				// SendableMail
 				sendErr := make(chan error)
 				go func() {
-					sendErr <- m.sender.Send(tt.mail)
+					sendErr <- m.sender.Send(context.Background(), tt.mail)
 				}()
 
 				// Wait for the SMTP conversation to complete
@@ -424,10 +624,10 @@ func TestSMTPProtocolExchange(t *testing.T) {
 				m := New(socket.Addr().String(), nil)
 
 				// Call into the sender directly, giving it the mock
-				// sendableEmail
+				// SendableMail
 				sendErr := make(chan error)
 				go func() {
-					sendErr <- m.sender.Send(tt.mail)
+					sendErr <- m.sender.Send(context.Background(), tt.mail)
 				}()
 
 				// Wait for the SMTP conversation to complete
@@ -451,3 +651,491 @@ func TestSMTPProtocolExchange(t *testing.T) {
 		})
 	}
 }
+
+// TestSendContext ensures a stalled SMTP server does not block SendContext
+// beyond the deadline of the provided context, for both sender
+// implementations and at different stages of the SMTP conversation.
+func TestSendContext(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+
+		// Called once the connection is accepted, impersonating the server up
+		// to the point it stalls.
+		connFn func(c *connAsserts)
+	}{
+		{
+			name:   "no greeting",
+			connFn: func(c *connAsserts) {},
+		},
+		{
+			name: "stalled rcpt",
+			connFn: func(c *connAsserts) {
+				c.Respond("220 localhost ESMTP bananas\r\n")
+
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250 localhost Hola\r\n")
+
+				c.Expect("MAIL FROM:<from@example.org>\r\n")
+				c.Respond("250 OK\r\n")
+
+				c.Expect("RCPT TO:<to@example.org>\r\n")
+			},
+		},
+	}
+
+	newSender := map[string]func(addr string) (Sender, error){
+		"Plaintext": func(addr string) (Sender, error) {
+			return newSenderWithStartTLS(addr), nil
+		},
+		"Explicit_TLS": func(addr string) (Sender, error) {
+			// The server never completes the TLS handshake.
+			return newSenderWithExplicitTLS(addr, nil)
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		for senderName, fn := range newSender {
+			senderName, fn := senderName, fn
+			t.Run(tt.name+"/"+senderName, func(t *testing.T) {
+				t.Parallel()
+
+				socket, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatalf("failed to bind to localhost: %v", err)
+				}
+				defer socket.Close()
+
+				release := make(chan struct{})
+				defer close(release)
+				go func() {
+					conn, err := socket.Accept()
+					if err != nil {
+						return
+					}
+					defer conn.Close()
+
+					if senderName == "Plaintext" {
+						tt.connFn(newConnAsserts(conn, t))
+					}
+
+					// Stall until the test completes.
+					<-release
+				}()
+
+				sender, err := fn(socket.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+				defer cancel()
+
+				err = sender.Send(ctx, &mockMail{
+					toAddrs:  []string{"to@example.org"},
+					fromAddr: "from@example.org",
+					mime:     "bananas",
+				})
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+				}
+			})
+		}
+	}
+}
+
+// TestSendContext_cancelled ensures an already-cancelled context causes
+// SendContext to return the context error without sending.
+func TestSendContext_cancelled(t *testing.T) {
+	t.Parallel()
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	m := New(socket.Addr().String(), nil)
+	m.From("from@example.org")
+	m.To("to@example.org")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := m.SendContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+// TestStartTLSPolicy ensures the STARTTLS sender upgrades the connection
+// according to the configured TLSPolicy, failing closed when TLS is required
+// but unavailable.
+func TestStartTLSPolicy(t *testing.T) {
+	t.Parallel()
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{
+			{
+				Certificate: [][]byte{testCertBytes},
+				PrivateKey:  testRSAKey,
+			},
+		},
+	}
+
+	transaction := func(c *connAsserts) {
+		c.Expect("MAIL FROM:<from@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("RCPT TO:<to@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("DATA\r\n")
+		c.Respond("354 OK\r\n")
+		c.Expect("bananas\r\n.\r\n")
+		c.Respond("250 Will do friend\r\n")
+		c.Expect("QUIT\r\n")
+		c.Respond("221 Adios\r\n")
+	}
+
+	tests := []struct {
+		name     string
+		policy   TLSPolicy
+		startTLS bool
+
+		// Called after the EHLO command, advertising STARTTLS if startTLS is
+		// true.
+		connFn  func(c *connAsserts)
+		wantErr error
+	}{
+		{
+			name:     "opportunistic without starttls",
+			policy:   TLSOpportunistic,
+			startTLS: false,
+			connFn:   transaction,
+		},
+		{
+			name:     "opportunistic with starttls",
+			policy:   TLSOpportunistic,
+			startTLS: true,
+			connFn: func(c *connAsserts) {
+				c.Expect("STARTTLS\r\n")
+				c.Respond("220 Ready\r\n")
+
+				c = newConnAsserts(tls.Server(c.Conn, serverConfig), t)
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250 localhost Hola\r\n")
+
+				transaction(c)
+			},
+		},
+		{
+			name:     "required without starttls",
+			policy:   TLSRequired,
+			startTLS: false,
+			connFn: func(c *connAsserts) {
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantErr: ErrStartTLSNotSupported,
+		},
+		{
+			name:     "required with starttls",
+			policy:   TLSRequired,
+			startTLS: true,
+			connFn: func(c *connAsserts) {
+				c.Expect("STARTTLS\r\n")
+				c.Respond("220 Ready\r\n")
+
+				c = newConnAsserts(tls.Server(c.Conn, serverConfig), t)
+				c.Expect("EHLO localhost\r\n")
+				c.Respond("250 localhost Hola\r\n")
+
+				transaction(c)
+			},
+		},
+		{
+			name:     "disabled with starttls",
+			policy:   TLSDisabled,
+			startTLS: true,
+			connFn:   transaction,
+		},
+		{
+			name:     "unknown policy fails closed",
+			policy:   TLSPolicy(42),
+			startTLS: false,
+			connFn: func(c *connAsserts) {
+				c.Expect("QUIT\r\n")
+				c.Respond("221 Adios\r\n")
+			},
+			wantErr: ErrStartTLSNotSupported,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			socket, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("failed to bind to localhost: %v", err)
+			}
+			defer socket.Close()
+
+			handlerDone := make(chan struct{})
+			go func() {
+				defer close(handlerDone)
+
+				conn, err := socket.Accept()
+				if err != nil {
+					panic(err)
+				}
+				defer conn.Close()
+
+				c := newConnAsserts(conn, t)
+				c.Respond("220 localhost ESMTP bananas\r\n")
+
+				c.Expect("EHLO localhost\r\n")
+				if tt.startTLS {
+					c.Respond("250-localhost Hola\r\n")
+					c.Respond("250 STARTTLS\r\n")
+				} else {
+					c.Respond("250 localhost Hola\r\n")
+				}
+
+				tt.connFn(c)
+			}()
+
+			// Trust the self-signed test certificate for the upgrade.
+			roots := x509.NewCertPool()
+			roots.AddCert(testCert)
+
+			sender := newSenderWithStartTLS(socket.Addr().String())
+			sender.tlsPolicy = tt.policy
+			sender.tlsConfig = &tls.Config{
+				RootCAs:    roots,
+				ServerName: "127.0.0.1",
+			}
+
+			err = sender.Send(context.Background(), &mockMail{
+				toAddrs:  []string{"to@example.org"},
+				fromAddr: "from@example.org",
+				mime:     "bananas",
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("got %v, want %v", err, tt.wantErr)
+			}
+
+			var smtpErr *SMTPError
+			if tt.wantErr != nil && (!errors.As(err, &smtpErr) || smtpErr.Stage != StageStartTLS) {
+				t.Errorf("got %v, want %s stage error", err, StageStartTLS)
+			}
+
+			select {
+			case <-handlerDone:
+			case <-time.After(15 * time.Second):
+				t.Fatal("timeout waiting for SMTP conversation to complete")
+			}
+		})
+	}
+}
+
+// TestMailYakSetTLSPolicy ensures the TLS policy is applied to the STARTTLS
+// senders, including failover hosts, and rejected for other senders.
+func TestMailYakSetTLSPolicy(t *testing.T) {
+	t.Parallel()
+
+	m := New("primary:25", nil)
+	if err := m.SetRetryPolicy(RetryPolicy{}, "backup:25"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetTLSPolicy(TLSRequired); err != nil {
+		t.Fatal(err)
+	}
+
+	// Failover hosts configured afterwards inherit the policy.
+	if err := m.SetRetryPolicy(RetryPolicy{}, "backup:25", "another:25"); err != nil {
+		t.Fatal(err)
+	}
+
+	relays := m.sender.(*retrySender).relays
+	if len(relays) != 3 {
+		t.Fatalf("got %d relays, want 3", len(relays))
+	}
+	for _, r := range relays {
+		if got := r.sender.(*senderWithStartTLS).tlsPolicy; got != TLSRequired {
+			t.Errorf("%s: got policy %v, want %v", r.host, got, TLSRequired)
+		}
+	}
+
+	explicit, err := NewWithTLS("primary:465", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := explicit.SetTLSPolicy(TLSRequired); err == nil {
+		t.Error("expected error setting the TLS policy for an explicit TLS sender")
+	}
+
+	m.UseSender(&scriptedSender{})
+	if err := m.SetTLSPolicy(TLSRequired); err == nil {
+		t.Error("expected error setting the TLS policy for a custom sender")
+	}
+}
+
+// TestMailYakSetStartTLSConfig ensures the STARTTLS tls.Config is cloned, and
+// the ServerName inferred from the host of each relay if not set.
+func TestMailYakSetStartTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	m := New("primary.example.org:25", nil)
+	if err := m.SetRetryPolicy(RetryPolicy{}, "backup.example.org:25"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetStartTLSConfig(config); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes made by the caller after the config is set are not applied.
+	config.MinVersion = tls.VersionTLS10
+
+	// Failover hosts configured afterwards use the same config.
+	if err := m.SetRetryPolicy(RetryPolicy{}, "backup.example.org:25", "another.example.org:25"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"primary.example.org", "backup.example.org", "another.example.org"}
+	for i, r := range m.sender.(*retrySender).relays {
+		got := r.sender.(*senderWithStartTLS).tlsConfig
+		if got == config {
+			t.Errorf("%s: tls.Config was not cloned", r.host)
+		}
+		if got.MinVersion != tls.VersionTLS12 {
+			t.Errorf("%s: got MinVersion %x, want %x", r.host, got.MinVersion, tls.VersionTLS12)
+		}
+		if got.ServerName != want[i] {
+			t.Errorf("%s: got ServerName %q, want %q", r.host, got.ServerName, want[i])
+		}
+	}
+
+	// An explicit ServerName is used for every relay.
+	if err := m.SetStartTLSConfig(&tls.Config{ServerName: "relay.example.org"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range m.sender.(*retrySender).relays {
+		if got := r.sender.(*senderWithStartTLS).tlsConfig.ServerName; got != "relay.example.org" {
+			t.Errorf("%s: got ServerName %q, want %q", r.host, got, "relay.example.org")
+		}
+	}
+
+	explicit, err := NewWithTLS("primary.example.org:465", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := explicit.SetStartTLSConfig(config); err == nil {
+		t.Error("expected error setting the STARTTLS config for an explicit TLS sender")
+	}
+}
+
+// TestStartTLSClientCertificate ensures the tls.Config set with
+// SetStartTLSConfig is used for the STARTTLS upgrade, presenting the client
+// certificate to a server requiring one.
+func TestStartTLSClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	// The self-signed test certificate is used by both the server and the
+	// client.
+	pool := x509.NewCertPool()
+	pool.AddCert(testCert)
+
+	cert := tls.Certificate{
+		Certificate: [][]byte{testCertBytes},
+		PrivateKey:  testRSAKey,
+	}
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+
+	socket, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to bind to localhost: %v", err)
+	}
+	defer socket.Close()
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+
+		conn, err := socket.Accept()
+		if err != nil {
+			panic(err)
+		}
+		defer conn.Close()
+
+		c := newConnAsserts(conn, t)
+		c.Respond("220 localhost ESMTP bananas\r\n")
+
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250-localhost Hola\r\n")
+		c.Respond("250 STARTTLS\r\n")
+
+		c.Expect("STARTTLS\r\n")
+		c.Respond("220 Ready\r\n")
+
+		tlsConn := tls.Server(conn, serverConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			t.Errorf("tls handshake: %v", err)
+			return
+		}
+		if n := len(tlsConn.ConnectionState().PeerCertificates); n != 1 {
+			t.Errorf("got %d client certificates, want 1", n)
+		}
+
+		c = newConnAsserts(tlsConn, t)
+		c.Expect("EHLO localhost\r\n")
+		c.Respond("250 localhost Hola\r\n")
+
+		c.Expect("MAIL FROM:<from@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("RCPT TO:<to@example.org>\r\n")
+		c.Respond("250 OK\r\n")
+		c.Expect("DATA\r\n")
+		c.Respond("354 OK\r\n")
+		c.Expect("bananas\r\n.\r\n")
+		c.Respond("250 Will do friend\r\n")
+		c.Expect("QUIT\r\n")
+		c.Respond("221 Adios\r\n")
+	}()
+
+	m := New(socket.Addr().String(), nil)
+	if err := m.SetTLSPolicy(TLSRequired); err != nil {
+		t.Fatal(err)
+	}
+	err = m.SetStartTLSConfig(&tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.sender.Send(context.Background(), &mockMail{
+		toAddrs:  []string{"to@example.org"},
+		fromAddr: "from@example.org",
+		mime:     "bananas",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-handlerDone:
+	case <-time.After(15 * time.Second):
+		t.Fatal("timeout waiting for SMTP conversation to complete")
+	}
+}
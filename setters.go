@@ -2,6 +2,7 @@ package mailyak
 
 import (
 	"mime"
+	"time"
 )
 
 // To sets a list of recipient addresses.
@@ -18,6 +19,9 @@ import (
 //	}
 //
 //	mail.To(tos...)
+//
+// Display names containing non-ASCII characters are encoded according to
+// RFC 2047 when the email is built.
 func (m *MailYak) To(addrs ...string) {
 	m.toAddrs = []string{}
 
@@ -45,6 +49,9 @@ func (m *MailYak) To(addrs ...string) {
 //	}
 //
 //	mail.Bcc(bccs...)
+//
+// Display names containing non-ASCII characters are encoded according to
+// RFC 2047 when the email is built.
 func (m *MailYak) Bcc(addrs ...string) {
 	m.bccAddrs = []string{}
 
@@ -78,6 +85,29 @@ func (m *MailYak) WriteBccHeader(shouldWrite bool) {
 	m.writeBccHeader = shouldWrite
 }
 
+// AllowPartialDelivery controls the behaviour when the SMTP server rejects a
+// recipient address. Defaults to false.
+//
+// By default, a rejected recipient aborts the send and the email is not
+// delivered to any recipient. When allow is true, rejected recipients are
+// recorded and the email is delivered to all the recipients accepted by the
+// server.
+//
+// If any recipients are rejected, Send() returns a *PartialDeliveryError
+// listing the accepted and rejected addresses:
+//
+//	err := mail.Send()
+//
+//	var partial *mailyak.PartialDeliveryError
+//	if errors.As(err, &partial) {
+//	    for _, r := range partial.Rejected {
+//	        log.Printf("%s rejected: %d %s", r.Address, r.Code, r.Message)
+//	    }
+//	}
+func (m *MailYak) AllowPartialDelivery(allow bool) {
+	m.allowPartial = allow
+}
+
 // Cc sets a list of carbon copy (CC) addresses.
 //
 // You can pass one or more addresses to this method, which are viewable to the other recipients.
@@ -92,6 +122,9 @@ func (m *MailYak) WriteBccHeader(shouldWrite bool) {
 //	}
 //
 //	mail.Cc(ccs...)
+//
+// Display names containing non-ASCII characters are encoded according to
+// RFC 2047 when the email is built.
 func (m *MailYak) Cc(addrs ...string) {
 	m.ccAddrs = []string{}
 
@@ -126,6 +159,9 @@ func (m *MailYak) FromName(name string) {
 // ReplyTo sets the Reply-To email address.
 //
 // Setting a ReplyTo address is optional.
+//
+// As with To(), a display name containing non-ASCII characters is encoded
+// according to RFC 2047 when the email is built.
 func (m *MailYak) ReplyTo(addr string) {
 	m.replyTo = m.trimRegex.ReplaceAllString(addr, "")
 }
@@ -137,6 +173,27 @@ func (m *MailYak) Subject(sub string) {
 	m.subject = mime.QEncoding.Encode("UTF-8", m.trimRegex.ReplaceAllString(sub, ""))
 }
 
+// InReplyTo sets the In-Reply-To header to the Message-IDs of the emails this
+// email is a reply to, threading it into the conversation.
+//
+//	mail.InReplyTo(original.MessageID())
+//
+// The angle brackets are added to each ID if not included.
+func (m *MailYak) InReplyTo(ids ...string) {
+	m.inReplyTo = m.formatMessageIDs(ids)
+}
+
+// References sets the References header to the Message-IDs of the emails in the
+// conversation this email belongs to, typically the References of the email
+// being replied to followed by its Message-ID.
+//
+//	mail.References(append(originalReferences, original.MessageID())...)
+//
+// The angle brackets are added to each ID if not included.
+func (m *MailYak) References(ids ...string) {
+	m.references = m.formatMessageIDs(ids)
+}
+
 // AddHeader adds an arbitrary email header.
 // It appends to any existing values associated with key.
 //
@@ -146,6 +203,7 @@ func (m *MailYak) Subject(sub string) {
 // example, BCC themselves in a password reset email to a different user.
 func (m *MailYak) AddHeader(name, value string) {
 	key := m.trimRegex.ReplaceAllString(name, "")
+	m.addHeaderName(key)
 	m.headers[key] = append(m.headers[key], mime.QEncoding.Encode("UTF-8", m.trimRegex.ReplaceAllString(value, "")))
 }
 
@@ -158,7 +216,17 @@ func (m *MailYak) AddHeader(name, value string) {
 // method may enable an attacker to override the standard headers and, for
 // example, BCC themselves in a password reset email to a different user.
 func (m *MailYak) SetHeader(name, value string) {
-	m.headers[m.trimRegex.ReplaceAllString(name, "")] = []string{mime.QEncoding.Encode("UTF-8", m.trimRegex.ReplaceAllString(value, ""))}
+	key := m.trimRegex.ReplaceAllString(name, "")
+	m.addHeaderName(key)
+	m.headers[key] = []string{mime.QEncoding.Encode("UTF-8", m.trimRegex.ReplaceAllString(value, ""))}
+}
+
+// addHeaderName records the custom header name so headers are written in the
+// order they were first added.
+func (m *MailYak) addHeaderName(name string) {
+	if _, ok := m.headers[name]; !ok {
+		m.headerOrder = append(m.headerOrder, name)
+	}
 }
 
 // LocalName sets the sender domain name.
@@ -170,3 +238,39 @@ func (m *MailYak) SetHeader(name, value string) {
 func (m *MailYak) LocalName(name string) {
 	m.localName = m.trimRegex.ReplaceAllString(name, "")
 }
+
+// SetClock sets the function used to obtain the current time for the Date
+// header, which defaults to time.Now.
+//
+// Together with SetBoundaryGenerator() and SetMessageID(), this allows MimeBuf()
+// to generate byte-for-byte reproducible output, such as for comparing
+// generated emails against golden files in tests:
+//
+//	mail.SetClock(func() time.Time {
+//	    return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+//	})
+//
+// Passing nil restores the default.
+func (m *MailYak) SetClock(now func() time.Time) {
+	m.clock = now
+}
+
+// SetBoundaryGenerator sets the function used to generate the MIME boundaries
+// separating the parts of the email, which defaults to generating
+// cryptographically random boundaries.
+//
+// Boundaries must not appear in the content of the email - a predictable
+// boundary allows a malicious user to inject content into the email, so a
+// custom generator should only be used for testing:
+//
+//	var n int
+//	mail.SetBoundaryGenerator(func() (string, error) {
+//	    n++
+//	    return fmt.Sprintf("boundary-%d", n), nil
+//	})
+//
+// Passing nil restores the default. The boundaries of S/MIME and PGP/MIME
+// entities are always random.
+func (m *MailYak) SetBoundaryGenerator(fn func() (string, error)) {
+	m.boundaryGen = fn
+}
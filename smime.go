@@ -0,0 +1,205 @@
+package mailyak
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SMIMEOptions configures the S/MIME (RFC 8551) signing and/or encryption of
+// emails.
+//
+// If SignerCert and SignerKey are set the email is signed, and if Recipients
+// is non-empty the email is encrypted. When both are configured, the email is
+// signed and then encrypted.
+type SMIMEOptions struct {
+	// SignerCert is the certificate of the signer, included in the
+	// signature.
+	SignerCert *x509.Certificate
+
+	// SignerKey is the private key matching SignerCert, and must be either a
+	// *rsa.PrivateKey or an *ecdsa.PrivateKey.
+	SignerKey crypto.Signer
+
+	// Intermediates are any intermediate certificates included in the
+	// signature to allow recipients to build a chain to a trusted root.
+	Intermediates []*x509.Certificate
+
+	// Recipients are the certificates of the recipients the email is
+	// encrypted to. Only RSA recipient keys are supported.
+	//
+	// Include the sender's own certificate to allow the sender to decrypt the
+	// sent email.
+	Recipients []*x509.Certificate
+}
+
+// smimeWrapper wraps MIME entities in S/MIME structures according to a
+// validated SMIMEOptions.
+type smimeWrapper struct {
+	opts SMIMEOptions
+
+	// now returns the signing time, following the clock set by SetClock().
+	now func() time.Time
+}
+
+// SetSMIME configures m to sign and/or encrypt emails using S/MIME, wrapping
+// the email body and attachments in a multipart/signed or
+// application/pkcs7-mime entity:
+//
+//	err := mail.SetSMIME(&mailyak.SMIMEOptions{
+//	    SignerCert: cert,
+//	    SignerKey:  privateKey,
+//	    Recipients: []*x509.Certificate{recipientCert},
+//	})
+//
+// Passing nil disables S/MIME. S/MIME and PGP/MIME cannot be used together.
+//
+// When S/MIME is enabled the MIME content is buffered in memory so it can be
+// signed and encrypted before being sent.
+func (m *MailYak) SetSMIME(opts *SMIMEOptions) error {
+	if opts == nil {
+		m.smime = nil
+		return nil
+	}
+
+	signing := opts.SignerCert != nil || opts.SignerKey != nil
+	if signing && (opts.SignerCert == nil || opts.SignerKey == nil) {
+		return errors.New("mailyak: s/mime signing requires both a certificate and key")
+	}
+	if !signing && len(opts.Recipients) == 0 {
+		return errors.New("mailyak: s/mime requires a signer or recipients")
+	}
+	if m.pgp != nil {
+		return errors.New("mailyak: s/mime cannot be used with pgp")
+	}
+
+	// Reject unsupported keys now, rather than once the headers have been
+	// written when sending.
+	if signing {
+		if _, err := pkcs7SignatureAlgorithm(opts.SignerKey); err != nil {
+			return err
+		}
+	}
+	for _, cert := range opts.Recipients {
+		if _, err := pkcs7RecipientKey(cert); err != nil {
+			return err
+		}
+	}
+
+	m.smime = &smimeWrapper{opts: *opts, now: m.now}
+	return nil
+}
+
+// signing returns true if entities are signed.
+func (s *smimeWrapper) signing() bool {
+	return s.opts.SignerCert != nil
+}
+
+// wrap writes the S/MIME signed and/or encrypted form of the MIME entity to w.
+func (s *smimeWrapper) wrap(w io.Writer, entity []byte) error {
+	// The entity must be in canonical form before signing or encrypting, as
+	// any conversion in transit would invalidate the signature.
+	entity = canonicalCRLF(entity)
+
+	if s.signing() {
+		buf := &bytes.Buffer{}
+		if err := s.writeSigned(buf, entity); err != nil {
+			return err
+		}
+		entity = buf.Bytes()
+	}
+
+	if len(s.opts.Recipients) == 0 {
+		_, err := w.Write(entity)
+		return err
+	}
+
+	return s.writeEnveloped(w, entity)
+}
+
+// writeSigned writes a multipart/signed entity containing entity and a
+// detached PKCS #7 signature.
+func (s *smimeWrapper) writeSigned(w io.Writer, entity []byte) error {
+	sig, err := pkcs7DetachedSignature(entity, s.opts.SignerCert, s.opts.SignerKey, s.opts.Intermediates, s.now())
+	if err != nil {
+		return err
+	}
+
+	return writeMultipartSigned(w, entity, "application/pkcs7-signature", "sha-256", func(w io.Writer) error {
+		const header = "Content-Type: application/pkcs7-signature; name=\"smime.p7s\"\r\n" +
+			"Content-Transfer-Encoding: base64\r\n" +
+			"Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n"
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		return writeBase64Lines(w, sig)
+	})
+}
+
+// writeMultipartSigned writes a multipart/signed (RFC 1847) entity containing
+// entity, followed by the signature part written by writeSig.
+//
+// writeSig must write the complete signature part, including headers, and end
+// with a CRLF.
+func writeMultipartSigned(w io.Writer, entity []byte, protocol, micalg string, writeSig func(w io.Writer) error) error {
+	boundary, err := randomBoundary()
+	if err != nil {
+		return err
+	}
+
+	// The signed entity is written verbatim between the boundaries - the CRLF
+	// preceding the closing boundary belongs to the boundary, not the entity.
+	if _, err := fmt.Fprintf(w, "Content-Type: multipart/signed;\r\n\tprotocol=\"%s\";\r\n\tmicalg=%s;\r\n\tboundary=\"%s\"\r\n\r\n--%s\r\n", protocol, micalg, boundary, boundary); err != nil {
+		return err
+	}
+	if _, err := w.Write(entity); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "\r\n--%s\r\n", boundary); err != nil {
+		return err
+	}
+	if err := writeSig(w); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "--%s--\r\n", boundary)
+	return err
+}
+
+// writeEnveloped writes an application/pkcs7-mime entity containing entity
+// encrypted to the configured recipients.
+func (s *smimeWrapper) writeEnveloped(w io.Writer, entity []byte) error {
+	enveloped, err := pkcs7Encrypt(entity, s.opts.Recipients)
+	if err != nil {
+		return err
+	}
+
+	const header = "Content-Type: application/pkcs7-mime;\r\n\tsmime-type=enveloped-data; name=\"smime.p7m\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n" +
+		"Content-Disposition: attachment; filename=\"smime.p7m\"\r\n\r\n"
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+	return writeBase64Lines(w, enveloped)
+}
+
+// writeBase64Lines writes data to w as base64, broken into CRLF terminated
+// lines of maxLineLen characters.
+func writeBase64Lines(w io.Writer, data []byte) error {
+	b64 := base64.StdEncoding.EncodeToString(data)
+	for len(b64) > 0 {
+		n := maxLineLen
+		if n > len(b64) {
+			n = len(b64)
+		}
+		if _, err := io.WriteString(w, b64[:n]+"\r\n"); err != nil {
+			return err
+		}
+		b64 = b64[n:]
+	}
+	return nil
+}
@@ -0,0 +1,407 @@
+package mailyak
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSMIMEMail returns a MailYak with a body and attachment to be wrapped.
+func newSMIMEMail(t *testing.T) *MailYak {
+	t.Helper()
+
+	m := New("mail.host.com:25", nil)
+	m.From("from@itsallbroken.com")
+	m.To("to@example.org")
+	m.Subject("S/MIME test")
+	m.Plain().Set("Secret\nplain")
+	m.HTML().Set("<b>Secret</b>")
+	m.Attach("test.txt", strings.NewReader("attachment"))
+
+	return m
+}
+
+// readSMIMEEntity parses msg, returning the headers and the raw body.
+func readSMIMEEntity(t *testing.T, msg []byte) (mail.Header, []byte) {
+	t.Helper()
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(parsed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Header, body
+}
+
+// verifySMIMESignature verifies the multipart/signed entity, returning the
+// signed content.
+func verifySMIMESignature(t *testing.T, header mail.Header, body []byte, cert *x509.Certificate) []byte {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "multipart/signed" || params["protocol"] != "application/pkcs7-signature" || params["micalg"] != "sha-256" {
+		t.Fatalf("unexpected content type %q", header.Get("Content-Type"))
+	}
+
+	// Extract the signed content verbatim from between the boundaries.
+	delim := []byte("--" + params["boundary"] + "\r\n")
+	start := bytes.Index(body, delim) + len(delim)
+	end := bytes.Index(body, []byte("\r\n--"+params["boundary"]+"\r\n"))
+	if start < len(delim) || end < start {
+		t.Fatalf("failed to find signed content:\n%s", body)
+	}
+	content := body[start:end]
+
+	// Read the signature part.
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	if _, err := r.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	sigPart, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := sigPart.Header.Get("Content-Type"); !strings.HasPrefix(got, "application/pkcs7-signature") {
+		t.Fatalf("unexpected signature content type %q", got)
+	}
+	sigB64, err := ioutil.ReadAll(sigPart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Replace(string(sigB64), "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Decode the SignedData.
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatal(err)
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		t.Fatalf("content type = %v, want %v", ci.ContentType, oidSignedData)
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sd.Certificates.Bytes, cert.Raw) {
+		t.Error("signer certificate not included")
+	}
+	var si pkcs7SignerInfo
+	if _, err := asn1.Unmarshal(sd.SignerInfos.Bytes, &si); err != nil {
+		t.Fatal(err)
+	}
+	if si.SID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Errorf("signer serial = %v, want %v", si.SID.SerialNumber, cert.SerialNumber)
+	}
+
+	// Check the message digest attribute matches the signed content.
+	digest := sha256.Sum256(content)
+	rest := si.SignedAttrs.Bytes
+	found := false
+	for len(rest) > 0 {
+		var attr pkcs7Attribute
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			t.Fatal(err)
+		}
+		if !attr.Type.Equal(oidAttributeMessageDigest) {
+			continue
+		}
+		var got []byte
+		if _, err := asn1.Unmarshal(attr.Values.Bytes, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, digest[:]) {
+			t.Fatal("message digest does not match signed content")
+		}
+		found = true
+	}
+	if !found {
+		t.Fatal("message digest attribute not found")
+	}
+
+	// Verify the signature over the attributes, encoded as a SET OF.
+	attrs := append([]byte{}, si.SignedAttrs.FullBytes...)
+	attrs[0] = 0x31
+	attrDigest := sha256.Sum256(attrs)
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, attrDigest[:], si.Signature)
+	case *ecdsa.PublicKey:
+		var esig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(si.Signature, &esig); err != nil {
+			t.Fatal(err)
+		}
+		if !ecdsa.Verify(pub, attrDigest[:], esig.R, esig.S) {
+			t.Fatal("ecdsa signature invalid")
+		}
+	}
+	if err != nil {
+		t.Fatalf("signature invalid: %v", err)
+	}
+
+	return content
+}
+
+// decryptSMIME decrypts the application/pkcs7-mime entity using key,
+// returning the decrypted content.
+func decryptSMIME(t *testing.T, header mail.Header, body []byte, key *rsa.PrivateKey) []byte {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mediaType != "application/pkcs7-mime" || params["smime-type"] != "enveloped-data" {
+		t.Fatalf("unexpected content type %q", header.Get("Content-Type"))
+	}
+
+	der, err := base64.StdEncoding.DecodeString(strings.Replace(string(body), "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatal(err)
+	}
+	if !ci.ContentType.Equal(oidEnvelopedData) {
+		t.Fatalf("content type = %v, want %v", ci.ContentType, oidEnvelopedData)
+	}
+	var ed pkcs7EnvelopedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &ed); err != nil {
+		t.Fatal(err)
+	}
+	var ri pkcs7RecipientInfo
+	if _, err := asn1.Unmarshal(ed.RecipientInfos.Bytes, &ri); err != nil {
+		t.Fatal(err)
+	}
+
+	cek, err := rsa.DecryptPKCS1v15(rand.Reader, key, ri.EncryptedKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var iv []byte
+	if _, err := asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := make([]byte, len(ed.EncryptedContentInfo.EncryptedContent.Bytes))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, ed.EncryptedContentInfo.EncryptedContent.Bytes)
+
+	return plain[:len(plain)-int(plain[len(plain)-1])]
+}
+
+// TestMailYakSetSMIME ensures emails are correctly signed and/or encrypted.
+func TestMailYakSetSMIME(t *testing.T) {
+	t.Parallel()
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(24),
+		Subject:      pkix.Name{CommonName: "from@itsallbroken.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	ecCertBytes, err := x509.CreateCertificate(rand.Reader, template, template, &ecKey.PublicKey, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecCert, err := x509.ParseCertificate(ecCertBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    SMIMEOptions
+		sign    bool
+		encrypt bool
+	}{
+		{
+			name: "sign rsa",
+			opts: SMIMEOptions{SignerCert: testCert, SignerKey: testRSAKey},
+			sign: true,
+		},
+		{
+			name: "sign ecdsa",
+			opts: SMIMEOptions{SignerCert: ecCert, SignerKey: ecKey},
+			sign: true,
+		},
+		{
+			name:    "encrypt",
+			opts:    SMIMEOptions{Recipients: []*x509.Certificate{testCert}},
+			encrypt: true,
+		},
+		{
+			name: "sign and encrypt",
+			opts: SMIMEOptions{
+				SignerCert: ecCert,
+				SignerKey:  ecKey,
+				Recipients: []*x509.Certificate{testCert},
+			},
+			sign:    true,
+			encrypt: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := newSMIMEMail(t)
+			if err := m.SetSMIME(&tt.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			buf, err := m.MimeBuf()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			header, body := readSMIMEEntity(t, buf.Bytes())
+			if header.Get("Subject") != "S/MIME test" {
+				t.Errorf("subject = %q, want %q", header.Get("Subject"), "S/MIME test")
+			}
+
+			if tt.encrypt {
+				if bytes.Contains(buf.Bytes(), []byte("Secret")) {
+					t.Fatal("encrypted message contains plaintext")
+				}
+				header, body = readSMIMEEntity(t, decryptSMIME(t, header, body, testRSAKey))
+			}
+
+			if tt.sign {
+				cert := tt.opts.SignerCert
+				header, body = readSMIMEEntity(t, verifySMIMESignature(t, header, body, cert))
+			}
+
+			// The unwrapped entity must be the original multipart/mixed body.
+			mediaType, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mediaType != "multipart/mixed" {
+				t.Errorf("inner content type = %q, want multipart/mixed", mediaType)
+			}
+			if !bytes.Contains(body, []byte("Secret")) || !bytes.Contains(body, []byte("test.txt")) {
+				t.Errorf("inner entity missing body or attachment:\n%s", body)
+			}
+		})
+	}
+}
+
+// TestMailYakSetSMIME_invalid ensures invalid S/MIME options are rejected.
+func TestMailYakSetSMIME_invalid(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		opts SMIMEOptions
+	}{
+		{"empty", SMIMEOptions{}},
+		{"no key", SMIMEOptions{SignerCert: testCert}},
+		{"no cert", SMIMEOptions{SignerKey: testRSAKey}},
+		{"unsupported signer key", SMIMEOptions{SignerCert: testCert, SignerKey: edKey}},
+		{"unsupported recipient key", SMIMEOptions{Recipients: []*x509.Certificate{testCert, {PublicKey: edKey.Public()}}}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if err := New("mail.host.com:25", nil).SetSMIME(&tt.opts); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
+
+// TestMailYakSetSMIME_clock ensures the signing time is read from the clock set
+// by SetClock.
+func TestMailYakSetSMIME_clock(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	m := newSMIMEMail(t)
+	m.SetClock(func() time.Time { return now })
+	if err := m.SetSMIME(&SMIMEOptions{SignerCert: testCert, SignerKey: testRSAKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := m.MimeBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, body := readSMIMEEntity(t, buf.Bytes())
+	_, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	if _, err := r.NextPart(); err != nil {
+		t.Fatal(err)
+	}
+	sigPart, err := r.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigB64, err := ioutil.ReadAll(sigPart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := base64.StdEncoding.DecodeString(strings.Replace(string(sigB64), "\r\n", "", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := asn1.Marshal(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(der, want) {
+		t.Errorf("signature does not contain signing time %v", now)
+	}
+}
@@ -1,6 +1,9 @@
 package mailyak
 
-import "bytes"
+import (
+	"bytes"
+	"io"
+)
 
 // BodyPart is a buffer holding the contents of an email MIME part.
 type BodyPart struct{ bytes.Buffer }
@@ -11,3 +14,15 @@ func (w *BodyPart) Set(s string) {
 	w.Reset()
 	w.WriteString(s)
 }
+
+// countWriter wraps an io.Writer, counting the bytes written to it.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}